@@ -0,0 +1,357 @@
+package statsd
+
+import (
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithTimingAggregation aggregates timings on the client, for servers that
+// don't support the timing type. Instead of sending each timing, the count,
+// sum, minimum, maximum and average of each bucket's timings since the last
+// flush are sent when the client flushes, as "bucket.count" and "bucket.sum"
+// counters and "bucket.min", "bucket.max" and "bucket.avg" gauges.
+//
+// Every timing is aggregated, regardless of its sample rate.
+func WithTimingAggregation() Option {
+	return func(c *statsdClient) {
+		c.aggregator.timings = map[aggregateKey]*timingAggregate{}
+	}
+}
+
+// WithRateSuffix sets the suffix of the per-second gauge sent for counters
+// recorded with CountRate. The default is ".per_second".
+func WithRateSuffix(suffix string) Option {
+	return func(c *statsdClient) {
+		c.rateSuffix = suffix
+	}
+}
+
+// WithRateInterval sets the interval that counters recorded with CountRate are
+// divided by to get their per-second rate. By default the flush interval is
+// used if there is one, or else the time since the last flush.
+func WithRateInterval(interval time.Duration) Option {
+	return func(c *statsdClient) {
+		c.rateInterval = interval
+	}
+}
+
+// WithUniqueEstimates makes CountUniqueApprox estimate the number of distinct
+// values in each bucket on the client with a HyperLogLog, instead of sending
+// every value in a set. The estimate is sent as a gauge when the client
+// flushes, so each bucket sends one line per flush no matter how many values
+// it sees. Each bucket uses 2^precision bytes of memory, and estimates have a
+// standard error of about 1.04/sqrt(2^precision). Precisions outside 4 to 16
+// are replaced with the default of 14, which uses 16KB per bucket and is
+// accurate to within about 1%.
+func WithUniqueEstimates(precision int) Option {
+	if precision < 4 || precision > 16 {
+		precision = 14
+	}
+	return func(c *statsdClient) {
+		c.aggregator.uniquePrecision = uint8(precision)
+	}
+}
+
+// WithExactUniques counts the distinct values of each CountUnique bucket on
+// the client, for servers that don't support the set type. Instead of sending
+// each value, the number of distinct values since the last flush is sent as a
+// gauge in bucket when the client flushes. Every distinct value is kept in
+// memory until then, so buckets with many values should use
+// CountUniqueApprox WithUniqueEstimates instead. Values are compared before
+// they're sanitized, since they're never sent.
+func WithExactUniques() Option {
+	return func(c *statsdClient) {
+		c.aggregator.exactUniques = true
+	}
+}
+
+// WithCumulativeCounters sends counters as running totals for the life of the
+// client instead of as the change since the last flush, for backends that
+// expect counters to only go up, such as Prometheus through a bridge. Every
+// count is added to its bucket's total, scaled up by its sample rate, and each
+// total is sent as a gauge every time the client flushes, whether or not it
+// changed.
+//
+// Totals start from zero whenever the process starts, so backends see a reset
+// as the value going down, which rate functions like Prometheus's rate()
+// already account for. Negative counts make totals go down too, so they're
+// best combined with WithNegativeCounts.
+func WithCumulativeCounters() Option {
+	return func(c *statsdClient) {
+		c.aggregator.cumulativeCounts = true
+	}
+}
+
+// aggregator accumulates metrics between flushes. Each kind of aggregate is
+// only kept if its map is non-nil.
+type aggregator struct {
+	sync.Mutex
+	timings map[aggregateKey]*timingAggregate
+
+	// Min/max gauges are always aggregated, so this map is created on first
+	// use.
+	minMaxGauges map[aggregateKey]*minMaxAggregate
+
+	// Counters recorded with CountRate, which are always aggregated, and
+	// when they were last sent.
+	rates    map[aggregateKey]*rateAggregate
+	lastEmit time.Time
+
+	// Values recorded with CountUniqueApprox, if uniquePrecision is set.
+	uniques         map[aggregateKey]*uniqueAggregate
+	uniquePrecision uint8
+
+	// Values recorded with CountUnique, if exactUniques is set.
+	sets         map[aggregateKey]*setAggregate
+	exactUniques bool
+
+	// Running totals of counters, if cumulativeCounts is set. They're never
+	// reset.
+	totals           map[aggregateKey]*totalAggregate
+	cumulativeCounts bool
+}
+
+// aggregateKey identifies a bucket with a particular set of tags.
+type aggregateKey struct {
+	bucket string
+	tags   string
+}
+
+func newAggregateKey(bucket string, tags []string) aggregateKey {
+	return aggregateKey{bucket: bucket, tags: strings.Join(tags, ",")}
+}
+
+type timingAggregate struct {
+	tags          []string
+	count         int
+	sum, min, max float64
+}
+
+func (a *aggregator) addTiming(bucket string, value float64, tags []string) {
+	a.Lock()
+	defer a.Unlock()
+
+	key := newAggregateKey(bucket, tags)
+	t, ok := a.timings[key]
+	if !ok {
+		t = &timingAggregate{tags: tags, min: value, max: value}
+		a.timings[key] = t
+	}
+	t.count++
+	t.sum += value
+	if value < t.min {
+		t.min = value
+	}
+	if value > t.max {
+		t.max = value
+	}
+}
+
+type minMaxAggregate struct {
+	tags     []string
+	min, max float64
+}
+
+func (a *aggregator) addMinMaxGauge(bucket string, value float64, tags []string) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.minMaxGauges == nil {
+		a.minMaxGauges = map[aggregateKey]*minMaxAggregate{}
+	}
+	key := newAggregateKey(bucket, tags)
+	g, ok := a.minMaxGauges[key]
+	if !ok {
+		g = &minMaxAggregate{tags: tags, min: value, max: value}
+		a.minMaxGauges[key] = g
+	}
+	if value < g.min {
+		g.min = value
+	}
+	if value > g.max {
+		g.max = value
+	}
+}
+
+type rateAggregate struct {
+	tags []string
+	sum  float64
+}
+
+func (a *aggregator) addRate(bucket string, value float64, tags []string) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.rates == nil {
+		a.rates = map[aggregateKey]*rateAggregate{}
+	}
+	key := newAggregateKey(bucket, tags)
+	r, ok := a.rates[key]
+	if !ok {
+		r = &rateAggregate{tags: tags}
+		a.rates[key] = r
+	}
+	r.sum += value
+}
+
+type uniqueAggregate struct {
+	tags     []string
+	estimate *hyperLogLog
+}
+
+func (a *aggregator) addUnique(bucket, value string, tags []string) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.uniques == nil {
+		a.uniques = map[aggregateKey]*uniqueAggregate{}
+	}
+	key := newAggregateKey(bucket, tags)
+	u, ok := a.uniques[key]
+	if !ok {
+		u = &uniqueAggregate{tags: tags, estimate: newHyperLogLog(a.uniquePrecision)}
+		a.uniques[key] = u
+	}
+	u.estimate.add(value)
+}
+
+type setAggregate struct {
+	tags   []string
+	values map[string]struct{}
+}
+
+func (a *aggregator) addSetValue(bucket, value string, tags []string) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.sets == nil {
+		a.sets = map[aggregateKey]*setAggregate{}
+	}
+	key := newAggregateKey(bucket, tags)
+	s, ok := a.sets[key]
+	if !ok {
+		s = &setAggregate{tags: tags, values: map[string]struct{}{}}
+		a.sets[key] = s
+	}
+	s.values[value] = struct{}{}
+}
+
+type totalAggregate struct {
+	tags  []string
+	total float64
+}
+
+func (a *aggregator) addToTotal(bucket string, value float64, tags []string) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.totals == nil {
+		a.totals = map[aggregateKey]*totalAggregate{}
+	}
+	key := newAggregateKey(bucket, tags)
+	t, ok := a.totals[key]
+	if !ok {
+		t = &totalAggregate{tags: tags}
+		a.totals[key] = t
+	}
+	t.total += value
+}
+
+// addCumulative adds a count to its running total WithCumulativeCounters,
+// sampling it like record does.
+func (c *statsdClient) addCumulative(sampleRate float64, bucket string, value float64, opts metricOptions) {
+	if sampleRate == DefaultSampleRate {
+		sampleRate = c.defaultSampleRate
+	}
+	if sampleRate < 1 {
+		if sampleRate <= rand.Float64() {
+			c.stats.sampledOut.Add(1)
+			return
+		}
+		value /= sampleRate
+	}
+	c.aggregator.addToTotal(bucket, value, opts.tags)
+}
+
+// rateIntervalSince returns the interval that counters recorded with CountRate
+// are divided by, given the time they were last sent.
+func (c *statsdClient) rateIntervalSince(lastEmit, now time.Time) time.Duration {
+	switch {
+	case c.rateInterval > 0:
+		return c.rateInterval
+	case c.flushInterval > 0:
+		return c.flushInterval
+	}
+	return now.Sub(lastEmit)
+}
+
+// emit records every aggregate through the client and resets them.
+func (a *aggregator) emit(c *statsdClient) {
+	a.Lock()
+	timings := a.timings
+	if timings != nil {
+		a.timings = map[aggregateKey]*timingAggregate{}
+	}
+	minMaxGauges := a.minMaxGauges
+	a.minMaxGauges = nil
+	rates := a.rates
+	a.rates = nil
+	uniques := a.uniques
+	a.uniques = nil
+	sets := a.sets
+	a.sets = nil
+	totals := make(map[aggregateKey]totalAggregate, len(a.totals))
+	for key, t := range a.totals {
+		totals[key] = *t
+	}
+	now := c.clock.Now()
+	interval := c.rateIntervalSince(a.lastEmit, now)
+	a.lastEmit = now
+	a.Unlock()
+
+	for key, t := range timings {
+		opts := metricOptions{tags: t.tags}
+		c.record(1, []byte(key.bucket+".count"), formatAggregate(float64(t.count)), COUNT_FLAG, opts)
+		c.record(1, []byte(key.bucket+".sum"), formatAggregate(t.sum), COUNT_FLAG, opts)
+		c.record(1, []byte(key.bucket+".min"), formatAggregate(t.min), GAUGE_FLAG, opts)
+		c.record(1, []byte(key.bucket+".max"), formatAggregate(t.max), GAUGE_FLAG, opts)
+		c.record(1, []byte(key.bucket+".avg"), formatAggregate(t.sum/float64(t.count)), GAUGE_FLAG, opts)
+	}
+	for key, g := range minMaxGauges {
+		opts := metricOptions{tags: g.tags}
+		opts.negativeGauge = g.min < 0
+		c.record(1, []byte(key.bucket+".min"), formatAggregate(g.min), GAUGE_FLAG, opts)
+		opts.negativeGauge = g.max < 0
+		c.record(1, []byte(key.bucket+".max"), formatAggregate(g.max), GAUGE_FLAG, opts)
+	}
+	for key, r := range rates {
+		opts := metricOptions{tags: r.tags}
+		c.record(1, []byte(key.bucket), formatAggregate(r.sum), COUNT_FLAG, opts)
+		if interval > 0 {
+			perSecond := r.sum / interval.Seconds()
+			opts.negativeGauge = perSecond < 0
+			c.record(1, []byte(key.bucket+c.rateSuffix), formatAggregate(perSecond), GAUGE_FLAG, opts)
+		}
+	}
+
+	for key, u := range uniques {
+		opts := metricOptions{tags: u.tags}
+		c.record(1, []byte(key.bucket), formatAggregate(math.Round(u.estimate.estimate())), GAUGE_FLAG, opts)
+	}
+	for key, s := range sets {
+		opts := metricOptions{tags: s.tags}
+		c.record(1, []byte(key.bucket), formatAggregate(float64(len(s.values))), GAUGE_FLAG, opts)
+	}
+	for key, t := range totals {
+		opts := metricOptions{tags: t.tags, negativeGauge: t.total < 0}
+		c.record(1, []byte(key.bucket), formatAggregate(t.total), GAUGE_FLAG, opts)
+	}
+}
+
+func formatAggregate(value float64) []byte {
+	return strconv.AppendFloat(nil, value, 'f', -1, 64)
+}
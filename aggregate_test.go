@@ -0,0 +1,157 @@
+package statsd
+
+import (
+	"github.com/stvp/go-udp-testing"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTimingAggregation(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithTimingAggregation())
+
+	expected := "a.count:3|c\na.sum:12|c\na.min:2|g\na.max:6|g\na.avg:4|g"
+	udp.ShouldReceiveOnly(t, expected, func() {
+		client.Timing("a", 4)
+		client.Timing("a", 2)
+		client.Timing("a", 6)
+		client.Flush()
+	})
+
+	// Aggregates are reset after each flush.
+	udp.ShouldNotReceive(t, "a.count", func() {
+		client.Count("b", 1, 1)
+		client.Flush()
+	})
+}
+
+func TestMinMaxGauge(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125")
+
+	udp.ShouldReceiveOnly(t, "depth.min:2|g\ndepth.max:7|g", func() {
+		client.MinMaxGauge("depth", 3)
+		client.MinMaxGauge("depth", 7)
+		client.MinMaxGauge("depth", 2)
+		client.Flush()
+	})
+
+	// Negative values are sent so that they set the gauge.
+	udp.ShouldReceiveOnly(t, "depth.min:0|g\ndepth.min:-2|g\ndepth.max:1|g", func() {
+		client.MinMaxGauge("depth", -2)
+		client.MinMaxGauge("depth", 1)
+		client.Flush()
+	})
+
+	// The window is reset after each flush.
+	udp.ShouldNotReceive(t, "depth", func() {
+		client.Count("b", 1, 1)
+		client.Flush()
+	})
+}
+
+func TestCountRate(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithRateInterval(10*time.Second))
+
+	udp.ShouldReceiveOnly(t, "requests:25|c\nrequests.per_second:2.5|g", func() {
+		client.CountRate("requests", 20)
+		client.CountRate("requests", 5)
+		client.Flush()
+	})
+
+	// Without an interval, the time since the last flush is used.
+	clock := newFakeClock()
+	client, _ = New("statsd://localhost:8125", withClock(clock), WithRateSuffix("_rate"))
+	udp.ShouldReceiveOnly(t, "requests:10|c\nrequests_rate:5|g", func() {
+		clock.Advance(2 * time.Second)
+		client.CountRate("requests", 10)
+		client.Flush()
+	})
+	udp.ShouldNotReceive(t, "requests", func() {
+		client.Count("b", 1, 1)
+		client.Flush()
+	})
+}
+
+func TestCountUniqueApprox(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithUniqueEstimates(14))
+
+	udp.ShouldReceiveOnly(t, "users:3|g", func() {
+		for _, user := range []string{"a", "b", "a", "c", "b"} {
+			client.CountUniqueApprox("users", user)
+		}
+		client.Flush()
+	})
+
+	// Estimates are reset after each flush.
+	udp.ShouldNotReceive(t, "users", func() {
+		client.Count("b", 1, 1)
+		client.Flush()
+	})
+
+	// Without the option, values are sent in a set.
+	client, _ = New("statsd://localhost:8125")
+	udp.ShouldReceiveOnly(t, "users:a|s", func() {
+		client.CountUniqueApprox("users", "a")
+		client.Flush()
+	})
+}
+
+func TestExactUniques(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithExactUniques())
+
+	udp.ShouldReceiveOnly(t, "users:3|g", func() {
+		for _, user := range []string{"a", "b", "a", "c", "b"} {
+			client.CountUnique("users", user)
+		}
+		client.Flush()
+	})
+	udp.ShouldReceiveOnly(t, "users:1|g|#plan:pro", func() {
+		client.CountUnique("users", "a", MetricTags("plan:pro"))
+		client.Flush()
+	})
+
+	// Values are cleared after each flush.
+	udp.ShouldNotReceive(t, "users", func() {
+		client.Count("b", 1, 1)
+		client.Flush()
+	})
+}
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		h := newHyperLogLog(14)
+		for i := 0; i < n; i++ {
+			h.add(strconv.Itoa(i))
+			h.add(strconv.Itoa(i))
+		}
+		if estimate := h.estimate(); math.Abs(estimate-float64(n)) > 0.05*float64(n) {
+			t.Errorf("Expected about %d distinct values but got %f", n, estimate)
+		}
+	}
+}
+
+func TestCumulativeCounters(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithCumulativeCounters())
+
+	udp.ShouldReceiveOnly(t, "requests:3|g", func() {
+		client.Count("requests", 2, 1)
+		client.Increment("requests")
+		client.Flush()
+	})
+
+	// Totals keep growing, and are sent even when they haven't changed.
+	udp.ShouldReceiveOnly(t, "requests:5|g", func() {
+		client.Add("requests", 2)
+		client.Flush()
+	})
+	udp.ShouldReceiveOnly(t, "requests:5|g", func() {
+		client.Flush()
+	})
+}
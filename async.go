@@ -0,0 +1,69 @@
+package statsd
+
+// WithAsync hands metrics to a background goroutine that formats them into
+// packets and writes them, so that recording a metric never waits on the
+// buffer lock or the network. Up to queueSize metrics are queued; once the
+// queue is full, recording a metric waits for room.
+//
+// Flush is queued behind every metric recorded before it, so a metric
+// recorded on the same goroutine before calling Flush is always included in
+// that flush. Metrics recorded after Close are dropped.
+func WithAsync(queueSize int) Option {
+	return func(c *statsdClient) {
+		c.queue = make(chan queued, queueSize)
+	}
+}
+
+// queued is either a metric line or a request to flush the buffer, which is
+// answered on flushed.
+type queued struct {
+	line    []byte
+	flushed chan error
+}
+
+// enqueue hands an item to the background goroutine, unless the client has
+// been closed.
+func (c *statsdClient) enqueue(item queued) bool {
+	select {
+	case c.queue <- item:
+		return true
+	case <-c.queueDone:
+		return false
+	}
+}
+
+// flushAsync flushes the buffer once everything queued before it has been
+// added to it.
+func (c *statsdClient) flushAsync() error {
+	c.aggregator.emit(c)
+
+	flushed := make(chan error, 1)
+	if !c.enqueue(queued{flushed: flushed}) {
+		return nil
+	}
+	select {
+	case err := <-flushed:
+		return err
+	case <-c.queueDone:
+		return nil
+	}
+}
+
+// queueLoop adds queued metrics to the buffer and flushes it when asked to,
+// until the client is closed.
+func (c *statsdClient) queueLoop() {
+	for {
+		select {
+		case item := <-c.queue:
+			if item.flushed != nil {
+				c.buffer.Lock()
+				item.flushed <- c.flushAll()
+				c.unlockBuffer()
+			} else {
+				c.sendNow(item.line)
+			}
+		case <-c.queueDone:
+			return
+		}
+	}
+}
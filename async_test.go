@@ -0,0 +1,38 @@
+package statsd
+
+import (
+	"github.com/stvp/go-udp-testing"
+	"testing"
+)
+
+func TestAsync(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithAsync(100))
+	defer client.Close()
+
+	// A flush includes every metric recorded before it on the same goroutine.
+	for i := 0; i < 20; i++ {
+		udp.ShouldReceiveOnly(t, "a:1|g\nb:1|c", func() {
+			client.Gauge("a", 1)
+			client.Count("b", 1, 1)
+			client.Flush()
+		})
+	}
+}
+
+func TestAsyncClose(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithAsync(100))
+
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client.Count("a", 1, 1)
+		client.Close()
+	})
+
+	// Metrics recorded and flushes made after closing don't block.
+	client.Count("b", 1, 1)
+	client.Flush()
+	if err := client.Close(); err != nil {
+		t.Errorf("Expected closing again to do nothing but got %#v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package statsd
+
+import (
+	"bytes"
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// WithBatchedWrites collects up to count full packets before sending them over
+// UDP with a single system call (sendmmsg) where the platform supports it,
+// which saves system calls at very high metric rates. Packets are sent
+// together once count of them have filled up, and whenever the client is
+// flushed. Other connections, and platforms without batched writes, write the
+// packets one at a time.
+func WithBatchedWrites(count int) Option {
+	return func(c *statsdClient) {
+		c.batchSize = count
+	}
+}
+
+// addToBatch queues a copy of packet to be written with the next batch,
+// writing the batch if it's full. It must be called with the buffer locked.
+func (c *statsdClient) addToBatch(packet []byte) error {
+	c.batch = append(c.batch, bytes.Clone(packet))
+	if len(c.batch) < c.batchSize {
+		return nil
+	}
+	return c.writeBatch()
+}
+
+// writeBatch writes every queued packet. It must be called with the buffer
+// locked.
+func (c *statsdClient) writeBatch() (err error) {
+	if len(c.batch) == 0 {
+		return nil
+	}
+	defer func() {
+		c.batch = c.batch[:0]
+	}()
+
+	udpConn, ok := c.conn.(*net.UDPConn)
+	if !ok {
+		for _, packet := range c.batch {
+			if writeErr := c.write(packet); err == nil {
+				err = writeErr
+			}
+		}
+		return err
+	}
+
+	size := 0
+	messages := make([]ipv4.Message, len(c.batch))
+	for i, packet := range c.batch {
+		messages[i].Buffers = [][]byte{packet}
+		size += len(packet)
+	}
+
+	conn := ipv4.NewPacketConn(udpConn)
+	written, sent := 0, 0
+	for sent < len(messages) {
+		n, err := conn.WriteBatch(messages[sent:], 0)
+		n = max(n, 0)
+		for _, m := range messages[sent : sent+n] {
+			written += m.N
+		}
+		sent += n
+		if err != nil || n == 0 {
+			return checkWrite(written, size, err)
+		}
+	}
+	return checkWrite(written, size, nil)
+}
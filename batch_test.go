@@ -0,0 +1,89 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// readPackets reads count datagrams from conn.
+func readPackets(t *testing.T, conn net.PacketConn, count int) []string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var packets []string
+	buf := make([]byte, 1500)
+	for len(packets) < count {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Expected %d packets but got %#v: %s", count, packets, err)
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+	return packets
+}
+
+func TestBatchedWrites(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, _ := NewWithPacketSize("statsd://"+server.LocalAddr().String(), 12, WithBatchedWrites(2))
+
+	// Nothing is written until two packets have filled up.
+	client.Count("a", 1, 1)
+	client.Count("b", 1, 1)
+	client.Count("c", 1, 1)
+	client.Count("d", 1, 1)
+	client.Count("e", 1, 1)
+	packets := readPackets(t, server, 2)
+	if packets[0] != "a:1|c\nb:1|c" || packets[1] != "c:1|c\nd:1|c" {
+		t.Errorf("Unexpected packets %#v", packets)
+	}
+
+	// Flushing writes a partial batch.
+	client.Count("f", 1, 1)
+	client.Count("g", 1, 1)
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	packets = readPackets(t, server, 2)
+	if packets[0] != "e:1|c\nf:1|c" || packets[1] != "g:1|c" {
+		t.Errorf("Unexpected packets %#v", packets)
+	}
+
+	// Batched packets that haven't been written are drained too.
+	client.Count("h", 1, 1)
+	client.Count("i", 1, 1)
+	client.Count("j", 1, 1)
+	if lines := string(client.Drain()); lines != "h:1|c\ni:1|c\nj:1|c" {
+		t.Errorf("Expected every line to be drained but got %#v", lines)
+	}
+}
+
+func BenchmarkFlushBatched(b *testing.B) {
+	benchmarkPackets(b, WithBatchedWrites(16))
+}
+
+func BenchmarkFlushUnbatched(b *testing.B) {
+	benchmarkPackets(b)
+}
+
+// benchmarkPackets records enough metrics to fill many small packets per
+// flush.
+func benchmarkPackets(b *testing.B, options ...Option) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer server.Close()
+	client, _ := NewWithPacketSize("statsd://"+server.LocalAddr().String(), 64, options...)
+
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 64; j++ {
+			client.Gauge("metrics.are.cool", 98765.4321)
+		}
+		client.Flush()
+	}
+}
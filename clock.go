@@ -0,0 +1,44 @@
+package statsd
+
+import (
+	"time"
+)
+
+// clock is the source of the current time and of timers. It's replaced in
+// tests so that timing doesn't depend on the real clock.
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) timer
+}
+
+type timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// withClock replaces the client's clock.
+func withClock(clock clock) Option {
+	return func(c *statsdClient) {
+		c.clock = clock
+	}
+}
+
+// -- realClock
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
@@ -0,0 +1,290 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"github.com/stvp/go-udp-testing"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves forward when Advance is called.
+type fakeClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	c        chan time.Time
+	deadline time.Time
+	stopped  bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	c.Lock()
+	defer c.Unlock()
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1), deadline: c.now.Add(d)}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward, firing any timers that expire.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+
+	var pending []*fakeTimer
+	for _, t := range c.timers {
+		if t.stopped {
+			continue
+		}
+		if !t.deadline.After(c.now) {
+			t.c <- c.now
+		} else {
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+}
+
+// WaitForTimers blocks until at least n timers are waiting to fire.
+func (c *fakeClock) WaitForTimers(n int) {
+	for {
+		c.Lock()
+		waiting := 0
+		for _, t := range c.timers {
+			if !t.stopped {
+				waiting++
+			}
+		}
+		c.Unlock()
+		if waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
+
+func TestTimer(t *testing.T) {
+	udp.SetAddr(":8125")
+	clock := newFakeClock()
+	client, _ := New("statsd://localhost:8125", withClock(clock))
+
+	udp.ShouldReceiveOnly(t, "a:1500|ms", func() {
+		stop := client.Timer("a")
+		clock.Advance(1500 * time.Millisecond)
+		stop()
+		client.Flush()
+	})
+}
+
+func TestTimerWithTags(t *testing.T) {
+	udp.SetAddr(":8125")
+	clock := newFakeClock()
+	client, _ := New("statsd://localhost:8125", withClock(clock), WithTags("env:test"))
+
+	udp.ShouldReceiveOnly(t, "request:250|ms|#env:test,route:/users,status:200", func() {
+		stop := client.TimerWithTags("request", "route:/users", "status:200")
+		clock.Advance(250 * time.Millisecond)
+		stop()
+		client.Flush()
+	})
+}
+
+func TestInstrument(t *testing.T) {
+	udp.SetAddr(":8125")
+	clock := newFakeClock()
+	client, _ := New("statsd://localhost:8125", withClock(clock))
+	failure := errors.New("failed")
+
+	udp.ShouldReceiveOnly(t, "db.duration:20|ms\ndb.success:1|c", func() {
+		err := client.Instrument("db", func() error {
+			clock.Advance(20 * time.Millisecond)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		client.Flush()
+	})
+
+	udp.ShouldReceiveOnly(t, "db.duration:0|ms\ndb.error:1|c", func() {
+		err := client.Instrument("db", func() error {
+			return failure
+		})
+		if err != failure {
+			t.Errorf("Expected %#v but got %#v", failure, err)
+		}
+		client.Flush()
+	})
+}
+
+func TestFlushIntervalClock(t *testing.T) {
+	udp.SetAddr(":8125")
+	clock := newFakeClock()
+	client, _ := New("statsd://localhost:8125", WithFlushInterval(time.Minute), withClock(clock))
+	defer client.Close()
+
+	client.Count("a", 1, 1)
+	clock.WaitForTimers(1)
+	udp.ShouldNotReceive(t, "a:1|c", func() {
+		clock.Advance(59 * time.Second)
+	})
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		clock.Advance(time.Second)
+	})
+}
+
+func TestIdleFlush(t *testing.T) {
+	udp.SetAddr(":8125")
+	clock := newFakeClock()
+	client, _ := New("statsd://localhost:8125", WithIdleFlush(100*time.Millisecond), withClock(clock))
+	defer client.Close()
+
+	// Each metric pushes the flush back until the client has been quiet for
+	// the whole period.
+	client.Count("a", 1, 1)
+	clock.WaitForTimers(1)
+	clock.Advance(60 * time.Millisecond)
+	client.Count("b", 1, 1)
+	udp.ShouldNotReceive(t, "a:1|c", func() {
+		clock.Advance(40 * time.Millisecond)
+	})
+	clock.WaitForTimers(1)
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:1|c", func() {
+		clock.Advance(60 * time.Millisecond)
+	})
+
+	// Nothing is flushed again until another metric is recorded.
+	udp.ShouldReceiveOnly(t, "c:1|c", func() {
+		client.Count("c", 1, 1)
+		clock.WaitForTimers(1)
+		clock.Advance(100 * time.Millisecond)
+	})
+}
+
+func TestFlushContext(t *testing.T) {
+	udp.SetAddr(":8125")
+	clock := newFakeClock()
+	ctx, cancel := context.WithCancel(context.Background())
+	client, _ := New("statsd://localhost:8125", WithFlushInterval(time.Minute), WithFlushContext(ctx), withClock(clock))
+	defer client.Close()
+
+	// Cancelling the context flushes one last time and stops the flusher.
+	client.Count("a", 1, 1)
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		cancel()
+	})
+	client.Count("b", 1, 1)
+	udp.ShouldNotReceive(t, "b:1|c", func() {
+		clock.Advance(time.Minute)
+	})
+
+	// FlushAndReset doesn't wait for the stopped flusher.
+	udp.ShouldReceiveOnly(t, "b:1|c", func() {
+		client.FlushAndReset()
+	})
+
+	// Without a flush interval, the buffer is flushed once.
+	ctx, cancel = context.WithCancel(context.Background())
+	client, _ = New("statsd://localhost:8125", WithFlushContext(ctx))
+	client.Count("c", 1, 1)
+	udp.ShouldReceiveOnly(t, "c:1|c", func() {
+		cancel()
+	})
+}
+
+func TestFlushAndReset(t *testing.T) {
+	udp.SetAddr(":8125")
+	clock := newFakeClock()
+	client, _ := New("statsd://localhost:8125", WithFlushInterval(time.Minute), withClock(clock))
+	defer client.Close()
+
+	clock.WaitForTimers(1)
+	clock.Advance(30 * time.Second)
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client.Count("a", 1, 1)
+		if err := client.FlushAndReset(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// The next automatic flush is a full interval after the manual one.
+	client.Count("b", 1, 1)
+	udp.ShouldNotReceive(t, "b:1|c", func() {
+		clock.Advance(59 * time.Second)
+	})
+	udp.ShouldReceiveOnly(t, "b:1|c", func() {
+		clock.Advance(time.Second)
+	})
+}
+
+func TestTagProvider(t *testing.T) {
+	udp.SetAddr(":8125")
+	clock := newFakeClock()
+	zone := "zone:a"
+	calls := 0
+	provider := func() []string {
+		calls++
+		return []string{zone}
+	}
+	client, _ := New("statsd://localhost:8125", WithTags("env:test"), WithTagProvider(provider, time.Minute), withClock(clock))
+
+	udp.ShouldReceiveOnly(t, "a:1|c|#env:test,zone:a\nb:1|c|#env:test,zone:a,role:web", func() {
+		client.Count("a", 1, 1)
+		client.Observe(Metric{Type: CountMetric, Bucket: "b", Value: 1, Tags: []string{"role:web"}})
+		client.Flush()
+	})
+
+	// The cached tags are used until the refresh interval passes.
+	zone = "zone:b"
+	udp.ShouldReceiveOnly(t, "a:1|c|#env:test,zone:a", func() {
+		client.Count("a", 1, 1)
+		client.Flush()
+	})
+	clock.Advance(time.Minute)
+	udp.ShouldReceiveOnly(t, "a:1|c|#env:test,zone:b", func() {
+		client.Count("a", 1, 1)
+		client.Flush()
+	})
+
+	tags := client.DefaultTags()
+	if !reflect.DeepEqual(tags, []string{"env:test", "zone:b"}) {
+		t.Errorf("Expected the current default tags but got %#v", tags)
+	}
+	tags[0] = "changed"
+	if tags := client.DefaultTags(); tags[0] != "env:test" {
+		t.Errorf("Expected a copy of the default tags but got %#v", tags)
+	}
+
+	if calls != 2 {
+		t.Errorf("Expected the provider to be called twice but it was called %d times", calls)
+	}
+}
@@ -0,0 +1,112 @@
+package statsd
+
+import (
+	"time"
+)
+
+// Config describes a Client for NewFromConfig. It's an alternative to
+// functional options for programs that load their configuration into a
+// struct. The zero value of every field means the default.
+type Config struct {
+	// Host is the "host:port" address of the statsd server. The default is
+	// "localhost:8125".
+	Host string
+
+	// Prefix is prepended to every metric name, followed by a period if it
+	// doesn't already end with one. It may not contain characters that are
+	// reserved in the statsd line format.
+	Prefix string
+
+	// PacketSize is the maximum number of bytes buffered before they're sent.
+	// The default is 512. Use a negative value to send each stat immediately.
+	PacketSize int
+
+	// Network is the network used to connect to the server, as in
+	// WithNetwork. The default is "udp".
+	Network string
+
+	// FlushInterval flushes the buffer in the background, as in
+	// WithFlushInterval. By default the buffer is only flushed when it's full
+	// or Flush is called.
+	FlushInterval time.Duration
+
+	// DefaultTags are added to every metric, as in WithTags.
+	DefaultTags []string
+
+	// ErrorHandler is called with errors that can't be returned to the
+	// caller, as in WithErrorHandler.
+	ErrorHandler func(error)
+}
+
+// options returns the options equivalent to the non-zero fields of cfg.
+func (cfg Config) options() []Option {
+	var options []Option
+	if cfg.Network != "" {
+		options = append(options, WithNetwork(cfg.Network))
+	}
+	if cfg.FlushInterval > 0 {
+		options = append(options, WithFlushInterval(cfg.FlushInterval))
+	}
+	if len(cfg.DefaultTags) > 0 {
+		options = append(options, WithTags(cfg.DefaultTags...))
+	}
+	if cfg.ErrorHandler != nil {
+		options = append(options, WithErrorHandler(cfg.ErrorHandler))
+	}
+	return options
+}
+
+// NewFromConfig creates a new Client as described by cfg. Like
+// NewWithPacketSize, it returns a no-op Client along with any error
+// connecting to the server.
+func NewFromConfig(cfg Config) (Client, error) {
+	packetSize := cfg.PacketSize
+	if packetSize == 0 {
+		packetSize = 512
+	}
+	c := newStatsdClient(packetSize, cfg.options())
+	empty := newEmptyClient(c, func() (Client, error) {
+		return NewFromConfig(cfg)
+	})
+
+	c.host = cfg.Host
+	if c.host == "" {
+		c.host = "localhost:8125"
+	}
+
+	prefix, err := c.validPrefix(cfg.Prefix)
+	if err != nil {
+		return empty.failed(err)
+	}
+	c.prefix = []byte(prefix)
+
+	c.conn, err = c.dialWithRetries()
+	if err != nil {
+		return empty.failed(err)
+	}
+	c.start()
+
+	return c, nil
+}
+
+// Config returns the client's effective configuration, such as for logging at
+// startup. The prefix includes its separator. A client that sends each stat
+// immediately has a negative PacketSize, and a client created with NewSRV has
+// no Host.
+func (c *statsdClient) Config() Config {
+	c.buffer.Lock()
+	packetSize := c.PacketSize
+	c.buffer.Unlock()
+	if packetSize <= 0 {
+		packetSize = -1
+	}
+	return Config{
+		Host:          c.host,
+		Prefix:        string(c.basePrefix()),
+		PacketSize:    packetSize,
+		Network:       c.network,
+		FlushInterval: c.flushInterval,
+		DefaultTags:   append([]string(nil), c.tags...),
+		ErrorHandler:  c.errorHandler,
+	}
+}
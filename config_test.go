@@ -0,0 +1,79 @@
+package statsd
+
+import (
+	"errors"
+	"github.com/stvp/go-udp-testing"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewFromConfig(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	// The zero value connects to localhost:8125 with a 512 byte buffer.
+	client, err := NewFromConfig(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:1|c", func() {
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 1)
+		client.Flush()
+	})
+
+	client, err = NewFromConfig(Config{
+		Host:        "localhost:8125",
+		Prefix:      "app",
+		PacketSize:  -1,
+		DefaultTags: []string{"env:test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp.ShouldReceiveOnly(t, "app.a:1|c|#env:test", func() {
+		client.Count("a", 1, 1)
+	})
+}
+
+func TestNewFromConfigErrors(t *testing.T) {
+	client, err := NewFromConfig(Config{Host: "broken:9999"})
+	if !errors.Is(err, ErrResolve) {
+		t.Errorf("Expected ErrResolve but got %#v", err)
+	}
+	if reflect.TypeOf(client).String() != "*statsd.emptyClient" {
+		t.Fatal("A bad connection should return an emptyClient.")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	client, err := New("statsd://localhost:8125/app", WithUnbuffered(), WithTags("env:test"), WithFlushInterval(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	cfg := client.Config()
+	cfg.ErrorHandler = nil
+	expected := Config{
+		Host:          "localhost:8125",
+		Prefix:        "app.",
+		PacketSize:    -1,
+		Network:       "udp",
+		FlushInterval: time.Second,
+		DefaultTags:   []string{"env:test"},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Expected %#v but got %#v", expected, cfg)
+	}
+
+	// The configuration can be used to create an equivalent client.
+	copied, err := NewFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copiedCfg := copied.Config(); !reflect.DeepEqual(copiedCfg, expected) {
+		t.Errorf("Expected %#v but got %#v", expected, copiedCfg)
+	}
+	copied.Close()
+}
@@ -0,0 +1,59 @@
+package statsd
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// cgroupFile lists the cgroups of the current process.
+var cgroupFile = "/proc/self/cgroup"
+
+// A container ID is the 64 hex digits used by Docker and containerd, or the
+// ID of an ECS Fargate task container.
+var containerIDPattern = regexp.MustCompile(`^(?:[0-9a-f]{64}|[0-9a-f]{32}-[0-9]+)$`)
+
+// WithContainerTag adds a "container_id" tag to every metric, with the ID of
+// the container the process runs in, for servers that don't attribute metrics
+// to containers themselves. The ID is read from /proc/self/cgroup once, when
+// the client is created, and both cgroup v1 and v2 formats are understood.
+// Nothing is added outside a container, or in one whose cgroup path doesn't
+// include its ID, such as with a private cgroup namespace.
+func WithContainerTag() Option {
+	return func(c *statsdClient) {
+		f, err := os.Open(cgroupFile)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		if id := containerID(f); id != "" {
+			c.tags = append(c.tags, "container_id:"+id)
+		}
+	}
+}
+
+// containerID returns the container ID found in the cgroup paths listed by r,
+// or "" if there isn't one. Each line is "hierarchy:controllers:path", where
+// cgroup v2 has an empty list of controllers. The ID is the last element of
+// the path, possibly with a runtime prefix and a ".scope" suffix added by
+// systemd, as in "/system.slice/docker-<id>.scope".
+func containerID(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		name := parts[2][strings.LastIndexByte(parts[2], '/')+1:]
+		name = strings.TrimSuffix(name, ".scope")
+		if i := strings.LastIndexByte(name, '-'); i >= 0 && !containerIDPattern.MatchString(name) {
+			name = name[i+1:]
+		}
+		if containerIDPattern.MatchString(name) {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package statsd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContainerID(t *testing.T) {
+	id := strings.Repeat("3f2a", 16)
+	tests := map[string]string{
+		// cgroup v1
+		"12:memory:/docker/" + id + "\n11:cpu:/docker/" + id:   id,
+		"4:pids:/kubepods/burstable/pod1234/" + id:             id,
+		"1:name=systemd:/system.slice/docker-" + id + ".scope": id,
+		"3:cpu:/ecs/5a0d/" + strings.Repeat("a", 32) + "-1234": strings.Repeat("a", 32) + "-1234",
+		// cgroup v2
+		"0::/system.slice/cri-containerd-" + id + ".scope":             id,
+		"0::/kubepods.slice/kubepods-pod1.slice/crio-" + id + ".scope": id,
+		"0::/": "",
+		"0::/user.slice/user-1000.slice/session-2.scope": "",
+		"":        "",
+		"garbage": "",
+	}
+	for cgroup, expected := range tests {
+		if got := containerID(strings.NewReader(cgroup)); got != expected {
+			t.Errorf("Expected %q from %q but got %q", expected, cgroup, got)
+		}
+	}
+}
+
+func TestWithContainerTag(t *testing.T) {
+	defer func(file string) { cgroupFile = file }(cgroupFile)
+	id := strings.Repeat("3f2a", 16)
+	cgroupFile = filepath.Join(t.TempDir(), "cgroup")
+	if err := os.WriteFile(cgroupFile, []byte("0::/docker/"+id+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newStatsdClient(512, []Option{WithTags("env:test"), WithContainerTag()})
+	if tags := strings.Join(client.tags, ","); tags != "env:test,container_id:"+id {
+		t.Errorf("Expected the container ID tag but got %q", tags)
+	}
+
+	// Outside a container, no tag is added.
+	cgroupFile = filepath.Join(t.TempDir(), "missing")
+	client = newStatsdClient(512, []Option{WithContainerTag()})
+	if len(client.tags) != 0 {
+		t.Errorf("Expected no tags but got %#v", client.tags)
+	}
+}
@@ -0,0 +1,28 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+)
+
+// reporterKey is the context key for a Client.
+type reporterKey struct{}
+
+// ContextWithReporter returns a copy of ctx that carries r, which can be
+// retrieved with ReporterFromContext. This lets middleware pass a
+// request-scoped client, such as one with request tags, to code further down
+// the call chain.
+func ContextWithReporter(ctx context.Context, r Client) context.Context {
+	return context.WithValue(ctx, reporterKey{}, r)
+}
+
+// ReporterFromContext returns the Client carried by ctx. If there isn't one, it
+// returns a Client that discards all stats, so callers don't need to check.
+func ReporterFromContext(ctx context.Context) Client {
+	if r, ok := ctx.Value(reporterKey{}).(Client); ok && r != nil {
+		return r
+	}
+	return &emptyClient{connect: func() (Client, error) {
+		return nil, errors.New("statsd: no client in context")
+	}}
+}
@@ -0,0 +1,28 @@
+package statsd
+
+import (
+	"context"
+	"github.com/stvp/go-udp-testing"
+	"reflect"
+	"testing"
+)
+
+func TestReporterFromContext(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	r := ReporterFromContext(context.Background())
+	if reflect.TypeOf(r).String() != "*statsd.emptyClient" {
+		t.Fatal("A context without a client should return an emptyClient.")
+	}
+	r.Count("a", 1, 1)
+	if err := r.Reconnect(); err == nil {
+		t.Error("Expected an error reconnecting a missing client")
+	}
+
+	client := goodClient("", 512)
+	ctx := ContextWithReporter(context.Background(), client)
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		ReporterFromContext(ctx).Count("a", 1, 1)
+		client.Flush()
+	})
+}
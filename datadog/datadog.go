@@ -0,0 +1,291 @@
+/*
+The datadog package adapts a statsd Client to the method set of the DataDog
+datadog-go client, so that code written against that library can switch to
+this one with few changes.
+
+Every method takes tags and a sample rate like datadog-go's do. Errors are
+only returned for invalid events and service checks; failures to send are
+handled by the underlying Client as usual. Events and service checks get the
+Client's default tags but, as in datadog-go, not its prefix.
+*/
+package datadog
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stvp/gostatsd"
+)
+
+// Client has the same methods as datadog-go's statsd.Client and sends stats
+// through a statsd Client.
+type Client struct {
+	client statsd.Client
+}
+
+// New returns a Client that sends stats through client.
+func New(client statsd.Client) *Client {
+	return &Client{client: client}
+}
+
+func (c *Client) observe(kind statsd.MetricType, name string, value float64, tags []string, rate float64) error {
+	c.client.Observe(statsd.Metric{Type: kind, Bucket: name, Value: value, Tags: tags, SampleRate: rate})
+	return nil
+}
+
+// Gauge sets a gauge.
+func (c *Client) Gauge(name string, value float64, tags []string, rate float64) error {
+	return c.observe(statsd.GaugeMetric, name, value, tags, rate)
+}
+
+// Count adds value to a counter.
+func (c *Client) Count(name string, value int64, tags []string, rate float64) error {
+	return c.observe(statsd.CountMetric, name, float64(value), tags, rate)
+}
+
+// Incr adds 1 to a counter.
+func (c *Client) Incr(name string, tags []string, rate float64) error {
+	return c.Count(name, 1, tags, rate)
+}
+
+// Decr subtracts 1 from a counter.
+func (c *Client) Decr(name string, tags []string, rate float64) error {
+	return c.Count(name, -1, tags, rate)
+}
+
+// Histogram records a value in a histogram.
+func (c *Client) Histogram(name string, value float64, tags []string, rate float64) error {
+	return c.observe(statsd.HistogramMetric, name, value, tags, rate)
+}
+
+// Distribution records a value in a distribution.
+func (c *Client) Distribution(name string, value float64, tags []string, rate float64) error {
+	return c.observe(statsd.DistributionMetric, name, value, tags, rate)
+}
+
+// Timing records a duration as a timing in milliseconds.
+func (c *Client) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	return c.TimeInMilliseconds(name, float64(value)/float64(time.Millisecond), tags, rate)
+}
+
+// TimeInMilliseconds records a timing in milliseconds.
+func (c *Client) TimeInMilliseconds(name string, value float64, tags []string, rate float64) error {
+	c.client.TimeInMilliseconds(name, value, rate, statsd.MetricTags(tags...))
+	return nil
+}
+
+// Set counts a unique value in a set.
+func (c *Client) Set(name string, value string, tags []string, rate float64) error {
+	c.client.Observe(statsd.Metric{Type: statsd.SetMetric, Bucket: name, SetValue: value, Tags: tags, SampleRate: rate})
+	return nil
+}
+
+// Flush sends any buffered stats.
+func (c *Client) Flush() error {
+	return c.client.Flush()
+}
+
+// Close flushes and closes the underlying Client.
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+// -- Events
+
+// EventPriority is the priority of an Event.
+type EventPriority string
+
+const (
+	Normal EventPriority = "normal"
+	Low    EventPriority = "low"
+)
+
+// EventAlertType is the kind of an Event.
+type EventAlertType string
+
+const (
+	Info    EventAlertType = "info"
+	Error   EventAlertType = "error"
+	Warning EventAlertType = "warning"
+	Success EventAlertType = "success"
+)
+
+// Event is a DogStatsD event. Title and Text are required.
+type Event struct {
+	Title          string
+	Text           string
+	Timestamp      time.Time
+	Hostname       string
+	AggregationKey string
+	Priority       EventPriority
+	SourceTypeName string
+	AlertType      EventAlertType
+	Tags           []string
+}
+
+// NewEvent returns an Event with the given title and text.
+func NewEvent(title, text string) *Event {
+	return &Event{Title: title, Text: text}
+}
+
+// Event sends an event.
+func (c *Client) Event(e *Event) error {
+	line, err := e.encode(c.client.DefaultTags())
+	if err != nil {
+		return err
+	}
+	c.client.SendRaw(line)
+	return nil
+}
+
+// SimpleEvent sends an event with only a title and text.
+func (c *Client) SimpleEvent(title, text string) error {
+	return c.Event(NewEvent(title, text))
+}
+
+// encode formats the event as a DogStatsD line:
+// "_e{<title length>,<text length>}:<title>|<text>|d:<timestamp>|...".
+// defaultTags come before the event's own tags.
+func (e *Event) encode(defaultTags []string) (string, error) {
+	if e.Title == "" {
+		return "", errors.New("datadog: event title is required")
+	}
+	if e.Text == "" {
+		return "", errors.New("datadog: event text is required")
+	}
+	if err := checkFields("event", e.Hostname, e.AggregationKey, e.SourceTypeName); err != nil {
+		return "", err
+	}
+
+	// Newlines can't appear in a line, so they're escaped as DogStatsD
+	// expects.
+	title := strings.ReplaceAll(e.Title, "\n", "\\n")
+	text := strings.ReplaceAll(e.Text, "\n", "\\n")
+
+	var b strings.Builder
+	b.WriteString("_e{")
+	b.WriteString(strconv.Itoa(len(title)))
+	b.WriteByte(',')
+	b.WriteString(strconv.Itoa(len(text)))
+	b.WriteString("}:")
+	b.WriteString(title)
+	b.WriteByte('|')
+	b.WriteString(text)
+	if !e.Timestamp.IsZero() {
+		b.WriteString("|d:")
+		b.WriteString(strconv.FormatInt(e.Timestamp.Unix(), 10))
+	}
+	writeField(&b, "h", e.Hostname)
+	writeField(&b, "k", e.AggregationKey)
+	writeField(&b, "p", string(e.Priority))
+	writeField(&b, "s", e.SourceTypeName)
+	writeField(&b, "t", string(e.AlertType))
+	writeTags(&b, append(defaultTags, e.Tags...))
+	return b.String(), nil
+}
+
+// -- Service checks
+
+// ServiceCheckStatus is the status of a ServiceCheck.
+type ServiceCheckStatus byte
+
+const (
+	Ok ServiceCheckStatus = iota
+	Warn
+	Critical
+	Unknown
+)
+
+// ServiceCheck is a DogStatsD service check. Name is required.
+type ServiceCheck struct {
+	Name      string
+	Status    ServiceCheckStatus
+	Timestamp time.Time
+	Hostname  string
+	Message   string
+	Tags      []string
+}
+
+// NewServiceCheck returns a ServiceCheck with the given name and status.
+func NewServiceCheck(name string, status ServiceCheckStatus) *ServiceCheck {
+	return &ServiceCheck{Name: name, Status: status}
+}
+
+// ServiceCheck sends a service check.
+func (c *Client) ServiceCheck(sc *ServiceCheck) error {
+	line, err := sc.encode(c.client.DefaultTags())
+	if err != nil {
+		return err
+	}
+	c.client.SendRaw(line)
+	return nil
+}
+
+// SimpleServiceCheck sends a service check with only a name and status.
+func (c *Client) SimpleServiceCheck(name string, status ServiceCheckStatus) error {
+	return c.ServiceCheck(NewServiceCheck(name, status))
+}
+
+// encode formats the service check as a DogStatsD line:
+// "_sc|<name>|<status>|d:<timestamp>|h:<hostname>|#<tags>|m:<message>".
+// defaultTags come before the service check's own tags.
+func (sc *ServiceCheck) encode(defaultTags []string) (string, error) {
+	if sc.Name == "" {
+		return "", errors.New("datadog: service check name is required")
+	}
+	if err := checkFields("service check", sc.Name, sc.Hostname); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("_sc|")
+	b.WriteString(sc.Name)
+	b.WriteByte('|')
+	b.WriteString(strconv.Itoa(int(sc.Status)))
+	if !sc.Timestamp.IsZero() {
+		b.WriteString("|d:")
+		b.WriteString(strconv.FormatInt(sc.Timestamp.Unix(), 10))
+	}
+	writeField(&b, "h", sc.Hostname)
+	writeTags(&b, append(defaultTags, sc.Tags...))
+	if sc.Message != "" {
+		// The message must come last, so it's escaped in case it contains
+		// what looks like another field.
+		b.WriteString("|m:")
+		b.WriteString(strings.NewReplacer("\n", "\\n", "m:", `m\:`).Replace(sc.Message))
+	}
+	return b.String(), nil
+}
+
+// checkFields returns an error if any of fields contains a newline, which
+// would end the line early. Unlike the text of an event or the message of a
+// service check, these fields have no escaping for it.
+func checkFields(kind string, fields ...string) error {
+	for _, field := range fields {
+		if strings.Contains(field, "\n") {
+			return fmt.Errorf("datadog: %s field %q contains a newline", kind, field)
+		}
+	}
+	return nil
+}
+
+// writeField adds "|<key>:<value>" if value isn't blank.
+func writeField(b *strings.Builder, key, value string) {
+	if value != "" {
+		b.WriteByte('|')
+		b.WriteString(key)
+		b.WriteByte(':')
+		b.WriteString(value)
+	}
+}
+
+// writeTags adds "|#<tags>" if there are any tags.
+func writeTags(b *strings.Builder, tags []string) {
+	if len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(tags, ","))
+	}
+}
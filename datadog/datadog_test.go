@@ -0,0 +1,122 @@
+package datadog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stvp/go-udp-testing"
+	"github.com/stvp/gostatsd"
+	mock "github.com/stvp/gostatsd/testing"
+)
+
+func newMock() *mock.MockStatsdClient {
+	return &mock.MockStatsdClient{
+		Counts:  map[string]string{},
+		Gauges:  map[string]string{},
+		Timings: map[string]string{},
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := newMock()
+	client := New(m)
+	tags := []string{"env:test"}
+
+	client.Incr("a", tags, 0.5)
+	client.Decr("b", nil, 1)
+	client.Gauge("c", 2.5, tags, 1)
+	client.Timing("d", 1500*time.Microsecond, nil, 1)
+	client.Histogram("e", 3, nil, 0.25)
+	client.Set("f", "x", nil, 1)
+
+	expected := []mock.Call{
+		{Type: statsd.CountMetric, Bucket: "a", Value: "1", SampleRate: 0.5, Tags: tags},
+		{Type: statsd.CountMetric, Bucket: "b", Value: "-1", SampleRate: 1},
+		{Type: statsd.GaugeMetric, Bucket: "c", Value: "2.5", SampleRate: 1, Tags: tags},
+		{Type: statsd.TimingMetric, Bucket: "d", Value: "1.5", SampleRate: 1},
+		{Type: statsd.HistogramMetric, Bucket: "e", Value: "3", SampleRate: 0.25},
+		{Type: statsd.SetMetric, Bucket: "f", Value: "x", SampleRate: 1},
+	}
+	if len(m.Calls) != len(expected) {
+		t.Fatalf("Expected %d calls but got %#v", len(expected), m.Calls)
+	}
+	for i, call := range m.Calls {
+		want := expected[i]
+		if call.Type != want.Type || call.Bucket != want.Bucket || call.Value != want.Value ||
+			call.SampleRate != want.SampleRate || len(call.Tags) != len(want.Tags) {
+			t.Errorf("Expected %#v but got %#v", want, call)
+		}
+	}
+}
+
+func TestEvent(t *testing.T) {
+	m := newMock()
+	client := New(m)
+
+	err := client.Event(&Event{
+		Title:     "Deploy\n",
+		Text:      "v1\nv2",
+		Timestamp: time.Unix(1700000000, 0),
+		Priority:  Low,
+		AlertType: Success,
+		Tags:      []string{"env:test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SimpleEvent("", "text"); err == nil {
+		t.Error("Expected an error for an event without a title")
+	}
+	if err := client.Event(&Event{Title: "a", Text: "b", Hostname: "web\n1"}); err == nil {
+		t.Error("Expected an error for an event with a newline in its hostname")
+	}
+
+	expected := `_e{8,6}:Deploy\n|v1\nv2|d:1700000000|p:low|t:success|#env:test`
+	if len(m.RawLines) != 1 || m.RawLines[0] != expected {
+		t.Errorf("Expected %#v but got %#v", expected, m.RawLines)
+	}
+}
+
+func TestServiceCheck(t *testing.T) {
+	m := newMock()
+	client := New(m)
+
+	err := client.ServiceCheck(&ServiceCheck{
+		Name:     "db.up",
+		Status:   Critical,
+		Hostname: "db1",
+		Message:  "down m:now",
+		Tags:     []string{"env:test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SimpleServiceCheck("web.up", Ok)
+	if err := client.SimpleServiceCheck("", Ok); err == nil {
+		t.Error("Expected an error for a service check without a name")
+	}
+	if err := client.SimpleServiceCheck("web\n.up", Ok); err == nil {
+		t.Error("Expected an error for a service check with a newline in its name")
+	}
+
+	expected := []string{`_sc|db.up|2|h:db1|#env:test|m:down m\:now`, "_sc|web.up|0"}
+	if len(m.RawLines) != 2 || m.RawLines[0] != expected[0] || m.RawLines[1] != expected[1] {
+		t.Errorf("Expected %#v but got %#v", expected, m.RawLines)
+	}
+}
+
+func TestDefaultTags(t *testing.T) {
+	udp.SetAddr(":8125")
+	c, err := statsd.New("statsd://localhost:8125/app", statsd.WithTags("env:test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(c)
+
+	// Events and service checks get the default tags but not the prefix.
+	udp.ShouldReceiveOnly(t, "_e{6,2}:Deploy|v1|#env:test,region:us\n_sc|db.up|0|#env:test", func() {
+		client.Event(&Event{Title: "Deploy", Text: "v1", Tags: []string{"region:us"}})
+		client.SimpleServiceCheck("db.up", Ok)
+		client.Flush()
+	})
+}
@@ -0,0 +1,88 @@
+package statsd
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// debugKinds names each statsd type flag in debug output.
+var debugKinds = map[string]string{
+	"c":  "count",
+	"g":  "gauge",
+	"ms": "timing",
+	"s":  "set",
+	"h":  "histogram",
+	"d":  "distribution",
+}
+
+// NewDebug creates a Client that logs each metric to logger as a readable
+// line, like "[statsd] count app.requests = 1", instead of sending it to a
+// statsd server. It's meant for local development. Any sample rate, tags and
+// timestamp are logged after the value.
+//
+// Metrics are logged as soon as they're recorded, regardless of options that
+// affect buffering or the connection.
+func NewDebug(logger *log.Logger, prefix string, options ...Option) (Client, error) {
+	c := newStatsdClient(0, options)
+	c.debugLogger = logger
+
+	validPrefix, err := c.validPrefix(prefix)
+	if err != nil {
+		return newEmptyClient(c, func() (Client, error) {
+			return NewDebug(logger, prefix, options...)
+		}).failed(err)
+	}
+	c.prefix = []byte(validPrefix)
+
+	c.conn, _ = c.dial()
+	c.start()
+
+	return c, nil
+}
+
+// debugConn is a net.Conn that logs the metric lines written to it.
+type debugConn struct {
+	logger *log.Logger
+}
+
+func (c *debugConn) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(p, newline) {
+		if len(line) > 0 {
+			c.logger.Print(formatDebugLine(string(line)))
+		}
+	}
+	return len(p), nil
+}
+
+// formatDebugLine turns a metric line like "a:1|c|@0.5" into a readable one
+// like "[statsd] count a = 1 @0.5". Lines that can't be parsed are logged as
+// they are.
+func formatDebugLine(line string) string {
+	fields := strings.Split(line, "|")
+	i := strings.IndexByte(fields[0], ':')
+	kind, ok := "", false
+	if len(fields) > 1 {
+		kind, ok = debugKinds[fields[1]]
+	}
+	if i < 0 || !ok {
+		return "[statsd] raw " + line
+	}
+
+	bucket, value := fields[0][:i], fields[0][i+1:]
+	return strings.Join(append([]string{"[statsd]", kind, bucket, "=", value}, fields[2:]...), " ")
+}
+
+func (c *debugConn) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *debugConn) Close() error                       { return nil }
+func (c *debugConn) LocalAddr() net.Addr                { return nil }
+func (c *debugConn) RemoteAddr() net.Addr               { return nil }
+func (c *debugConn) SetDeadline(t time.Time) error      { return nil }
+func (c *debugConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *debugConn) SetWriteDeadline(t time.Time) error { return nil }
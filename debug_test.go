@@ -0,0 +1,33 @@
+package statsd
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestDebug(t *testing.T) {
+	var out bytes.Buffer
+	client, err := NewDebug(log.New(&out, "", 0), "app", WithTags("env:test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Count("requests", 1, 1)
+	client.Gauge("depth", 3)
+	client.Timing("db", 12.5)
+	client.Histogram("size", 2, 0.999999)
+	client.HistogramBatch("sizes", []float64{1, 2, 3}, 1)
+	client.SendRaw("not a metric")
+	client.Flush()
+
+	expected := "[statsd] count app.requests = 1 #env:test\n" +
+		"[statsd] gauge app.depth = 3 #env:test\n" +
+		"[statsd] timing app.db = 12.5 #env:test\n" +
+		"[statsd] histogram app.size = 2 @0.999999 #env:test\n" +
+		"[statsd] histogram app.sizes = 1:2:3 #env:test\n" +
+		"[statsd] raw not a metric\n"
+	if out.String() != expected {
+		t.Errorf("Expected %#v but got %#v", expected, out.String())
+	}
+}
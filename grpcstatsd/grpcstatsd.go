@@ -0,0 +1,119 @@
+/*
+The grpcstatsd package provides gRPC interceptors that record the latency and
+outcome of each RPC to a statsd Client.
+
+For every RPC, the duration is recorded as a timing in
+"<side>.<method>.time" and the outcome is counted in "<side>.<method>.<code>",
+where side is "grpc.server" or "grpc.client", method is the sanitized full
+method name (eg. "helloworld_Greeter.SayHello") and code is the gRPC status
+code (eg. "OK" or "NotFound").
+*/
+package grpcstatsd
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/stvp/gostatsd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// Regex for sanitizing service and method names
+	invalidChars = regexp.MustCompile(`[^\w-]+`)
+)
+
+// UnaryServerInterceptor returns an interceptor that records stats for each
+// unary RPC handled by a server.
+func UnaryServerInterceptor(client statsd.Client) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		record(client, "grpc.server", info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns an interceptor that records stats for each
+// streaming RPC handled by a server.
+func StreamServerInterceptor(client statsd.Client) grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, stream)
+		record(client, "grpc.server", info.FullMethod, start, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns an interceptor that records stats for each
+// unary RPC made by a client.
+func UnaryClientInterceptor(client statsd.Client) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		record(client, "grpc.client", method, start, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns an interceptor that records stats for each
+// streaming RPC made by a client. Stats are recorded when the stream ends,
+// which is when RecvMsg first returns an error (io.EOF for a successful RPC),
+// or for client-streaming RPCs, whose server sends a single response, after
+// the first RecvMsg, such as the one made by CloseAndRecv. Streams abandoned
+// before then aren't recorded.
+func StreamClientInterceptor(client statsd.Client) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			record(client, "grpc.client", method, start, err)
+			return nil, err
+		}
+		return &clientStream{ClientStream: stream, client: client, method: method, start: start, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	client statsd.Client
+	method string
+	start  time.Time
+	done   bool
+
+	// Whether the server sends a stream of responses, rather than one.
+	serverStreams bool
+}
+
+func (s *clientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if (err != nil || !s.serverStreams) && !s.done {
+		s.done = true
+		if err == nil || err == io.EOF {
+			record(s.client, "grpc.client", s.method, s.start, nil)
+		} else {
+			record(s.client, "grpc.client", s.method, s.start, err)
+		}
+	}
+	return err
+}
+
+func record(client statsd.Client, side, fullMethod string, start time.Time, err error) {
+	bucket := side + "." + methodBucket(fullMethod)
+	client.TimingDuration(bucket+".time", time.Since(start))
+	client.Count(bucket+"."+status.Code(err).String(), 1, 1)
+}
+
+// methodBucket turns a full method name like "/helloworld.Greeter/SayHello"
+// into a bucket name like "helloworld_Greeter.SayHello".
+func methodBucket(fullMethod string) string {
+	parts := strings.Split(strings.TrimPrefix(fullMethod, "/"), "/")
+	for i, part := range parts {
+		parts[i] = invalidChars.ReplaceAllString(part, "_")
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,107 @@
+package grpcstatsd
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	mock "github.com/stvp/gostatsd/testing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newMock() *mock.MockStatsdClient {
+	return &mock.MockStatsdClient{
+		Counts:  map[string]string{},
+		Gauges:  map[string]string{},
+		Timings: map[string]string{},
+	}
+}
+
+func TestMethodBucket(t *testing.T) {
+	tests := map[string]string{
+		"/helloworld.Greeter/SayHello": "helloworld_Greeter.SayHello",
+		"/a.b.c.Service/Do-It":         "a_b_c_Service.Do-It",
+		"Bare":                         "Bare",
+	}
+
+	for method, expected := range tests {
+		if got := methodBucket(method); got != expected {
+			t.Errorf("Expected %#v but got %#v", expected, got)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	client := newMock()
+	interceptor := UnaryServerInterceptor(client)
+	info := &grpc.UnaryServerInfo{FullMethod: "/helloworld.Greeter/SayHello"}
+
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	})
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.NotFound, "nope")
+	})
+
+	for _, bucket := range []string{"grpc.server.helloworld_Greeter.SayHello.OK", "grpc.server.helloworld_Greeter.SayHello.NotFound"} {
+		if client.Counts[bucket] != "1" {
+			t.Errorf("Expected a count for %#v but got %#v", bucket, client.Counts)
+		}
+	}
+	if _, ok := client.Timings["grpc.server.helloworld_Greeter.SayHello.time"]; !ok {
+		t.Errorf("Expected a timing but got %#v", client.Timings)
+	}
+}
+
+type fakeClientStream struct {
+	grpc.ClientStream
+	err error
+}
+
+func (s *fakeClientStream) RecvMsg(m any) error {
+	return s.err
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	client := newMock()
+	interceptor := StreamClientInterceptor(client)
+
+	stream, _ := interceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/svc.Stream/Watch", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeClientStream{err: io.EOF}, nil
+	})
+	if len(client.Counts) > 0 {
+		t.Fatalf("Expected no stats before the stream ends but got %#v", client.Counts)
+	}
+
+	stream.RecvMsg(nil)
+	stream.RecvMsg(nil)
+	if client.Counts["grpc.client.svc_Stream.Watch.OK"] != "1" {
+		t.Errorf("Expected one OK count but got %#v", client.Counts)
+	}
+}
+
+func TestClientStreamingInterceptor(t *testing.T) {
+	client := newMock()
+	interceptor := StreamClientInterceptor(client)
+	streamer := func(err error) grpc.Streamer {
+		return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &fakeClientStream{err: err}, nil
+		}
+	}
+	desc := &grpc.StreamDesc{ClientStreams: true}
+
+	// The single response ends the RPC, so it's recorded then.
+	stream, _ := interceptor(context.Background(), desc, nil, "/svc.Upload/Put", streamer(nil))
+	stream.RecvMsg(nil)
+	if client.Counts["grpc.client.svc_Upload.Put.OK"] != "1" || client.TimingCalls() != 1 {
+		t.Errorf("Expected one OK count and a timing but got %#v", client.Counts)
+	}
+
+	stream, _ = interceptor(context.Background(), desc, nil, "/svc.Upload/Put", streamer(status.Error(codes.Internal, "boom")))
+	stream.RecvMsg(nil)
+	if client.Counts["grpc.client.svc_Upload.Put.Internal"] != "1" || client.TimingCalls() != 2 {
+		t.Errorf("Expected one Internal count and a timing but got %#v", client.Counts)
+	}
+}
@@ -0,0 +1,67 @@
+package statsd
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+)
+
+// Hashes values added to every hyperLogLog. Estimates never leave the process,
+// so a random seed is fine.
+var hyperLogLogSeed = maphash.MakeSeed()
+
+// hyperLogLog estimates the number of distinct values added to it using
+// 2^precision one-byte registers.
+type hyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint8) *hyperLogLog {
+	return &hyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}
+}
+
+func (h *hyperLogLog) add(value string) {
+	hash := maphash.String(hyperLogLogSeed, value)
+	index := hash >> (64 - h.precision)
+
+	// The rank is the position of the first set bit in the rest of the
+	// hash. The sentinel bit caps it for hashes that are all zeros.
+	rest := hash<<h.precision | 1<<(h.precision-1)
+	rank := uint8(bits.LeadingZeros64(rest)) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// estimate returns the estimated number of distinct values added, using linear
+// counting for small cardinalities where it's more accurate.
+func (h *hyperLogLog) estimate() float64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, register := range h.registers {
+		sum += math.Ldexp(1, -int(register))
+		if register == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		return m * math.Log(m/float64(zeros))
+	}
+	return estimate
+}
@@ -0,0 +1,62 @@
+package statsd
+
+import (
+	"time"
+)
+
+// WithIdleFlush flushes the buffer once no metric has been recorded for
+// quiet, so that the tail of a burst isn't left waiting for the buffer to fill
+// or the next flush interval, while a steady stream of metrics still fills
+// whole packets. It can be combined with WithFlushInterval. Call Close to stop
+// it.
+func WithIdleFlush(quiet time.Duration) Option {
+	return func(c *statsdClient) {
+		c.idleFlush = quiet
+	}
+}
+
+// touch notes that a metric was recorded, for WithIdleFlush.
+func (c *statsdClient) touch() {
+	if c.activity == nil {
+		return
+	}
+	c.lastActivity.Store(c.clock.Now().UnixNano())
+	select {
+	case c.activity <- struct{}{}:
+	default:
+	}
+}
+
+// idleFlushLoop flushes the buffer whenever metrics have been recorded and
+// then none have for the quiet period. Rather than restarting a timer for
+// every metric, it checks when the last one was recorded each time the timer
+// fires.
+func (c *statsdClient) idleFlushLoop() {
+	for {
+		select {
+		case <-c.activity:
+		case <-c.closed:
+			return
+		}
+
+		for wait := c.idleFlush; wait > 0; {
+			timer := c.clock.NewTimer(wait)
+			select {
+			case <-timer.C():
+			case <-c.closed:
+				timer.Stop()
+				return
+			}
+			last := time.Unix(0, c.lastActivity.Load())
+			wait = c.idleFlush - c.clock.Now().Sub(last)
+		}
+
+		// Metrics recorded from here on are either in this flush or wake the
+		// loop again.
+		select {
+		case <-c.activity:
+		default:
+		}
+		c.handleError(c.Flush())
+	}
+}
@@ -0,0 +1,129 @@
+package statsd
+
+import (
+	"time"
+)
+
+// MetricType is the kind of a Metric passed to Observe.
+type MetricType int
+
+const (
+	CountMetric MetricType = iota
+	GaugeMetric
+	TimingMetric
+	SetMetric
+	HistogramMetric
+	DistributionMetric
+)
+
+// flag returns the statsd type flag for t.
+func (t MetricType) flag() []byte {
+	switch t {
+	case CountMetric:
+		return COUNT_FLAG
+	case GaugeMetric:
+		return GAUGE_FLAG
+	case TimingMetric:
+		return TIMING_FLAG
+	case SetMetric:
+		return CARDINALITY_FLAG
+	case HistogramMetric:
+		return HISTOGRAM_FLAG
+	case DistributionMetric:
+		return DISTRIBUTION_FLAG
+	}
+	return nil
+}
+
+// Metric is a single stat of any type, for use with Observe.
+type Metric struct {
+	Type   MetricType
+	Bucket string
+
+	// Value is used by every type except SetMetric, which uses SetValue.
+	Value    float64
+	SetValue string
+
+	// A SampleRate of 0 uses the client's default sample rate for counters,
+	// timings, histograms and distributions, and 1 for gauges and sets.
+	SampleRate float64
+
+	// Tags are added after the client's default tags.
+	Tags []string
+
+	// Timestamp is the time the metric was taken, for backfilling. It's only
+	// sent if it's non-zero and the client was created WithTimestamps, since
+	// plain statsd servers don't support it.
+	Timestamp time.Time
+}
+
+// A MetricOption changes how a single metric is recorded.
+type MetricOption func(*metricOptions)
+
+// metricOptions holds settings for a single metric.
+type metricOptions struct {
+	// Added after the client's default tags.
+	tags []string
+
+	// Sent with the metric if non-zero and timestamps are enabled.
+	timestamp time.Time
+
+	// Whether to flush the buffer right after the metric is added to it.
+	flush bool
+
+	// Whether the metric is a gauge being set to a negative value, which
+	// needs to be reset to 0 first.
+	negativeGauge bool
+
+	// Whether to leave out all tags, including the client's default tags.
+	untagged bool
+
+	// Whether to write the metric straight to the connection instead of
+	// buffering it.
+	unbuffered bool
+
+	// Whether to skip checking the bucket name, for the counter of
+	// suspicious names itself.
+	unchecked bool
+}
+
+func newMetricOptions(opts []MetricOption) metricOptions {
+	var o metricOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// MetricTags adds tags to a single metric, after the client's default tags. A
+// tag with the same key as a default tag replaces it.
+func MetricTags(tags ...string) MetricOption {
+	return func(o *metricOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
+// TagsOf returns the tags added by opts. It's meant for other implementations
+// of Client, such as mocks, that need to see the tags of a metric.
+func TagsOf(opts ...MetricOption) []string {
+	return newMetricOptions(opts).tags
+}
+
+// FlushImmediately flushes the buffer, including the metric, as soon as the
+// metric is recorded. It's useful for important metrics that shouldn't wait
+// for the buffer to fill up.
+func FlushImmediately() MetricOption {
+	return func(o *metricOptions) {
+		o.flush = true
+	}
+}
+
+// Unbuffered writes the metric to the connection as soon as it's recorded, in
+// a packet of its own, without flushing the rest of the buffer. It's useful
+// for metrics that mustn't be lost if the process crashes before the next
+// flush, such as a marker that it's shutting down.
+func Unbuffered() MetricOption {
+	return func(o *metricOptions) {
+		o.unbuffered = true
+	}
+}
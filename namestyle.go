@@ -0,0 +1,69 @@
+package statsd
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NameStyle is how a client rewrites CamelCase bucket names, such as ones
+// written to match Go identifiers.
+type NameStyle int
+
+const (
+	// NamesAsIs sends bucket names unchanged. This is the default.
+	NamesAsIs NameStyle = iota
+
+	// NamesSnakeCase lowercases bucket names, separating words with
+	// underscores: "api.RequestLatency" becomes "api.request_latency".
+	NamesSnakeCase
+
+	// NamesDotted lowercases bucket names, separating words with periods:
+	// "api.RequestLatency" becomes "api.request.latency".
+	NamesDotted
+)
+
+// WithNameStyle rewrites every bucket name in the given style, so that names
+// are consistent however they're written. A run of capitals is treated as one
+// word, so "HTTPRequests" becomes "http_requests". It's applied before any
+// name transformer, and the prefix isn't rewritten.
+func WithNameStyle(style NameStyle) Option {
+	return func(c *statsdClient) {
+		c.nameStyle = style
+	}
+}
+
+// apply returns bucket rewritten in the style.
+func (s NameStyle) apply(bucket string) string {
+	var separator rune
+	switch s {
+	case NamesSnakeCase:
+		separator = '_'
+	case NamesDotted:
+		separator = '.'
+	default:
+		return bucket
+	}
+	if strings.IndexFunc(bucket, unicode.IsUpper) < 0 {
+		return bucket
+	}
+
+	var b strings.Builder
+	b.Grow(len(bucket) + 4)
+	prev := rune(-1)
+	for i, r := range bucket {
+		if unicode.IsUpper(r) {
+			next, _ := utf8.DecodeRuneInString(bucket[i+utf8.RuneLen(r):])
+			// A capital starts a word after a lowercase letter or digit, or
+			// when it's the last of a run of capitals followed by lowercase.
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && unicode.IsLower(next)) {
+				b.WriteRune(separator)
+			}
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String()
+}
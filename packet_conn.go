@@ -0,0 +1,90 @@
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// packetConn adapts an unconnected net.PacketConn to a net.Conn that writes
+// every packet to a destination address, which can be changed at any time.
+type packetConn struct {
+	net.PacketConn
+
+	mu   sync.RWMutex
+	addr net.Addr
+
+	// Whether PacketConn belongs to the caller of NewWithPacketConn, in
+	// which case it's left open.
+	borrowed bool
+}
+
+// NewWithPacketConn creates a new Client that sends stats to addr through
+// conn, which the caller has already opened, such as a socket inherited from
+// systemd or a fake in tests. Each packet is written with WriteTo. The caller
+// owns conn: Close and Reconnect leave it open, and it's reused after
+// Reconnect. The prefix and packet size are as for NewSRV and
+// NewWithPacketSize.
+//
+// Since there's nothing to connect to, the only error is an invalid prefix,
+// and no no-op Client is returned with it.
+func NewWithPacketConn(conn net.PacketConn, addr net.Addr, prefix string, packetSize int, options ...Option) (Client, error) {
+	c := newStatsdClient(packetSize, options)
+	validPrefix, err := c.validPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	c.prefix = []byte(validPrefix)
+
+	c.borrowedConn = &packetConn{PacketConn: conn, addr: addr, borrowed: true}
+	c.connectionless = true
+	c.conn, _ = c.dial()
+	c.start()
+
+	return c, nil
+}
+
+// listenPacket opens an unconnected UDP socket that writes to host.
+func listenPacket(network, host string) (net.Conn, error) {
+	addr, err := net.ResolveUDPAddr(network, host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrResolve, err)
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDial, err)
+	}
+	return &packetConn{PacketConn: conn, addr: addr}, nil
+}
+
+func (c *packetConn) destination() net.Addr {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.addr
+}
+
+func (c *packetConn) setDestination(addr net.Addr) {
+	c.mu.Lock()
+	c.addr = addr
+	c.mu.Unlock()
+}
+
+func (c *packetConn) Write(p []byte) (int, error) {
+	return c.PacketConn.WriteTo(p, c.destination())
+}
+
+func (c *packetConn) Read(p []byte) (int, error) {
+	n, _, err := c.PacketConn.ReadFrom(p)
+	return n, err
+}
+
+func (c *packetConn) Close() error {
+	if c.borrowed {
+		return nil
+	}
+	return c.PacketConn.Close()
+}
+
+func (c *packetConn) RemoteAddr() net.Addr {
+	return c.destination()
+}
@@ -0,0 +1,103 @@
+package statsd
+
+import (
+	"github.com/stvp/go-udp-testing"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectionlessUDP(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, err := New("statsd://localhost:8125", WithConnectionlessUDP())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client.Count("a", 1, 1)
+		client.Flush()
+	})
+
+	other, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := client.SetDestination(other.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Count("b", 1, 1)
+	client.Flush()
+	other.SetReadDeadline(time.Now().Add(time.Second))
+	packet := make([]byte, 512)
+	n, _, err := other.ReadFrom(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(packet[:n]) != "b:1|c" {
+		t.Errorf("Expected %#v but got %#v", "b:1|c", string(packet[:n]))
+	}
+}
+
+func TestSetDestinationConnected(t *testing.T) {
+	client := goodClient("", 512)
+	if err := client.SetDestination("localhost:8126"); err == nil {
+		t.Error("SetDestination should fail on a connected client.")
+	}
+}
+
+// recordingPacketConn records the packets written to it.
+type recordingPacketConn struct {
+	net.PacketConn
+	packets []string
+	addrs   []net.Addr
+	closed  bool
+}
+
+func (c *recordingPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.packets = append(c.packets, string(p))
+	c.addrs = append(c.addrs, addr)
+	return len(p), nil
+}
+
+func (c *recordingPacketConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewWithPacketConn(t *testing.T) {
+	conn := &recordingPacketConn{}
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8125}
+	client, err := NewWithPacketConn(conn, addr, "app", 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Count("a", 1, 1)
+	client.Gauge("b", 2)
+	client.Flush()
+	if err := client.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	client.Count("c", 1, 1)
+	client.Close()
+
+	expected := []string{"app.a:1|c\napp.b:2|g", "app.c:1|c"}
+	if len(conn.packets) != 2 || conn.packets[0] != expected[0] || conn.packets[1] != expected[1] {
+		t.Errorf("Expected %#v but got %#v", expected, conn.packets)
+	}
+	for _, written := range conn.addrs {
+		if written != addr {
+			t.Errorf("Expected packets to be written to %v but got %v", addr, written)
+		}
+	}
+	if conn.closed {
+		t.Error("The caller's connection shouldn't be closed.")
+	}
+
+	if _, err := NewWithPacketConn(conn, addr, "bad:prefix", 512); err == nil {
+		t.Error("Expected an error for an invalid prefix.")
+	}
+}
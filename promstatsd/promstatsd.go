@@ -0,0 +1,545 @@
+/*
+The promstatsd package provides a statsd Client that records metrics in
+Prometheus collectors registered with a prometheus.Registerer instead of
+sending them anywhere, so that code instrumented with a statsd Client can be
+scraped from a standard /metrics endpoint.
+
+Bucket names, after the prefix, become metric names, with every character
+that Prometheus doesn't allow replaced with an underscore, and tags become
+labels: "key:value" tags become a "key" label and tags without a value become
+a label set to "true". Metrics are mapped as follows:
+
+  - Counts become counters named "<bucket>_total". Negative counts are
+    reported to the error handler and dropped, since counters can't go down.
+  - Gauges become gauges named "<bucket>".
+  - Timings become histograms named "<bucket>_seconds", converted from
+    milliseconds.
+  - Histograms and distributions become histograms named "<bucket>".
+
+Every metric is recorded in full, regardless of its sample rate, since
+nothing is sent. Sets and raw lines aren't supported and are discarded.
+
+A metric name can only be used with one set of label names. Metrics that
+conflict with ones already registered are reported to the error handler once
+and then dropped.
+*/
+package promstatsd
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stvp/gostatsd"
+)
+
+var _ statsd.Client = (*Client)(nil)
+
+var (
+	// Characters that aren't allowed in metric and label names.
+	invalidNameChars  = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// Client records metrics in Prometheus collectors.
+type Client struct {
+	registerer   prometheus.Registerer
+	tags         []string
+	buckets      []float64
+	errorHandler func(error)
+
+	mu         sync.Mutex
+	prefix     string
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+// An Option configures a Client.
+type Option func(*Client)
+
+// WithPrefix prepends prefix and a period to every bucket name, before the
+// name is sanitized.
+func WithPrefix(prefix string) Option {
+	return func(c *Client) {
+		c.prefix = prefix
+	}
+}
+
+// WithTags adds tags to every metric, which become labels.
+func WithTags(tags ...string) Option {
+	return func(c *Client) {
+		c.tags = append(c.tags, tags...)
+	}
+}
+
+// WithBuckets sets the upper bounds of the buckets of the histograms created
+// for timings, histograms and distributions. The default is
+// prometheus.DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(c *Client) {
+		c.buckets = buckets
+	}
+}
+
+// WithErrorHandler sets a function that's called with metrics that can't be
+// recorded, such as because they conflict with registered collectors.
+func WithErrorHandler(handler func(error)) Option {
+	return func(c *Client) {
+		c.errorHandler = handler
+	}
+}
+
+// New returns a Client that registers its collectors with registerer.
+func New(registerer prometheus.Registerer, options ...Option) *Client {
+	c := &Client{
+		registerer: registerer,
+		buckets:    prometheus.DefBuckets,
+		counters:   map[string]*prometheus.CounterVec{},
+		gauges:     map[string]*prometheus.GaugeVec{},
+		histograms: map[string]*prometheus.HistogramVec{},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+func (c *Client) handleError(err error) {
+	if err != nil && c.errorHandler != nil {
+		c.errorHandler(err)
+	}
+}
+
+// metricName returns the sanitized name of a bucket.
+func (c *Client) metricName(bucket string) string {
+	c.mu.Lock()
+	if c.prefix != "" {
+		bucket = c.prefix + "." + bucket
+	}
+	c.mu.Unlock()
+
+	name := invalidNameChars.ReplaceAllString(bucket, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// labels returns the labels for the default tags followed by tags, and their
+// names in order.
+func (c *Client) labels(tags []string) (prometheus.Labels, []string) {
+	labels := prometheus.Labels{}
+	for _, set := range [][]string{c.tags, tags} {
+		for _, tag := range set {
+			key, value, ok := strings.Cut(tag, ":")
+			if !ok {
+				value = "true"
+			}
+			key = invalidLabelChars.ReplaceAllString(key, "_")
+			if key != "" && key[0] >= '0' && key[0] <= '9' {
+				key = "_" + key
+			}
+			labels[key] = value
+		}
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return labels, names
+}
+
+// vecKey identifies a collector by its name and label names.
+func vecKey(name string, labelNames []string) string {
+	return name + "{" + strings.Join(labelNames, ",")
+}
+
+// register registers a new collector, returning false if it can't be. The
+// failure is reported to the error handler, and the caller should remember
+// it so that it's only reported once.
+func (c *Client) register(collector prometheus.Collector) bool {
+	if err := c.registerer.Register(collector); err != nil {
+		c.handleError(err)
+		return false
+	}
+	return true
+}
+
+func help(bucket string) string {
+	return "Recorded as the statsd bucket " + strconv.Quote(bucket) + "."
+}
+
+// counter returns the counter for bucket and tags, or nil if it can't be
+// registered.
+func (c *Client) counter(bucket string, tags []string) prometheus.Counter {
+	name := c.metricName(bucket) + "_total"
+	labels, labelNames := c.labels(tags)
+	key := vecKey(name, labelNames)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vec, ok := c.counters[key]
+	if !ok {
+		vec = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help(bucket)}, labelNames)
+		if !c.register(vec) {
+			vec = nil
+		}
+		c.counters[key] = vec
+	}
+	if vec == nil {
+		return nil
+	}
+	return vec.With(labels)
+}
+
+// gauge returns the gauge for bucket and tags, or nil if it can't be
+// registered.
+func (c *Client) gauge(bucket string, tags []string) prometheus.Gauge {
+	name := c.metricName(bucket)
+	labels, labelNames := c.labels(tags)
+	key := vecKey(name, labelNames)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vec, ok := c.gauges[key]
+	if !ok {
+		vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help(bucket)}, labelNames)
+		if !c.register(vec) {
+			vec = nil
+		}
+		c.gauges[key] = vec
+	}
+	if vec == nil {
+		return nil
+	}
+	return vec.With(labels)
+}
+
+// histogram returns the histogram for bucket and tags, with suffix added to
+// its name, or nil if it can't be registered.
+func (c *Client) histogram(bucket, suffix string, tags []string) prometheus.Observer {
+	name := c.metricName(bucket) + suffix
+	labels, labelNames := c.labels(tags)
+	key := vecKey(name, labelNames)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vec, ok := c.histograms[key]
+	if !ok {
+		vec = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help(bucket), Buckets: c.buckets}, labelNames)
+		if !c.register(vec) {
+			vec = nil
+		}
+		c.histograms[key] = vec
+	}
+	if vec == nil {
+		return nil
+	}
+	return vec.With(labels)
+}
+
+func (c *Client) count(bucket string, value float64, tags []string) {
+	if value < 0 {
+		c.handleError(errors.New("promstatsd: dropped negative count for " + strconv.Quote(bucket)))
+		return
+	}
+	if counter := c.counter(bucket, tags); counter != nil {
+		counter.Add(value)
+	}
+}
+
+func (c *Client) setGauge(bucket string, value float64, tags []string) {
+	if gauge := c.gauge(bucket, tags); gauge != nil {
+		gauge.Set(value)
+	}
+}
+
+func (c *Client) addGauge(bucket string, delta float64, tags []string) {
+	if gauge := c.gauge(bucket, tags); gauge != nil {
+		gauge.Add(delta)
+	}
+}
+
+// timing records a timing in milliseconds.
+func (c *Client) timing(bucket string, ms float64, tags []string) {
+	if histogram := c.histogram(bucket, "_seconds", tags); histogram != nil {
+		histogram.Observe(ms / 1000)
+	}
+}
+
+func (c *Client) observe(bucket string, value float64, tags []string) {
+	if histogram := c.histogram(bucket, "", tags); histogram != nil {
+		histogram.Observe(value)
+	}
+}
+
+func (c *Client) Count(bucket string, value, sampleRate float64, opts ...statsd.MetricOption) {
+	c.count(bucket, value, statsd.TagsOf(opts...))
+}
+
+func (c *Client) Increment(bucket string, opts ...statsd.MetricOption) {
+	c.count(bucket, 1, statsd.TagsOf(opts...))
+}
+
+func (c *Client) Add(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.count(bucket, value, statsd.TagsOf(opts...))
+}
+
+// CountRate records a count like Count does, since Prometheus calculates
+// rates itself.
+func (c *Client) CountRate(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.count(bucket, value, statsd.TagsOf(opts...))
+}
+
+// HistogramBuckets counts value in cumulative buckets like the statsd Client
+// does. A Prometheus histogram recorded with Histogram is usually a better
+// fit.
+func (c *Client) HistogramBuckets(bucket string, value float64, bounds []float64, opts ...statsd.MetricOption) {
+	tags := statsd.TagsOf(opts...)
+	for _, bound := range bounds {
+		if value <= bound {
+			c.count(bucket+".le_"+strings.ReplaceAll(strconv.FormatFloat(bound, 'f', -1, 64), ".", "_"), 1, tags)
+		}
+	}
+	c.count(bucket+".le_inf", 1, tags)
+}
+
+func (c *Client) Gauge(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.setGauge(bucket, value, statsd.TagsOf(opts...))
+}
+
+func (c *Client) GaugeBool(bucket string, value bool, opts ...statsd.MetricOption) {
+	if value {
+		c.setGauge(bucket, 1, statsd.TagsOf(opts...))
+	} else {
+		c.setGauge(bucket, 0, statsd.TagsOf(opts...))
+	}
+}
+
+func (c *Client) GaugeState(bucket string, state int, opts ...statsd.MetricOption) {
+	c.setGauge(bucket, float64(state), statsd.TagsOf(opts...))
+}
+
+// GaugeAndCount sets a gauge and counts the change in "<bucket>_changes_total".
+func (c *Client) GaugeAndCount(bucket string, value float64, opts ...statsd.MetricOption) {
+	tags := statsd.TagsOf(opts...)
+	c.setGauge(bucket, value, tags)
+	c.count(bucket+".changes", 1, tags)
+}
+
+func (c *Client) GaugeDelta(bucket string, delta float64, opts ...statsd.MetricOption) {
+	c.addGauge(bucket, delta, statsd.TagsOf(opts...))
+}
+
+// GaugeDeltaString adds a signed value to a gauge. Values that can't be
+// parsed are reported to the error handler.
+func (c *Client) GaugeDeltaString(bucket string, signedValue string, opts ...statsd.MetricOption) {
+	delta, err := strconv.ParseFloat(signedValue, 64)
+	if err != nil {
+		c.handleError(err)
+		return
+	}
+	c.addGauge(bucket, delta, statsd.TagsOf(opts...))
+}
+
+// MinMaxGauge sets a gauge like Gauge does, since a scrape only sees the
+// current value.
+func (c *Client) MinMaxGauge(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.setGauge(bucket, value, statsd.TagsOf(opts...))
+}
+
+func (c *Client) Timing(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.timing(bucket, value, statsd.TagsOf(opts...))
+}
+
+func (c *Client) TimingDuration(bucket string, duration time.Duration, opts ...statsd.MetricOption) {
+	c.timing(bucket, float64(duration)/float64(time.Millisecond), statsd.TagsOf(opts...))
+}
+
+func (c *Client) TimingMsInt(bucket string, ms int64, sampleRate float64, opts ...statsd.MetricOption) {
+	c.timing(bucket, float64(ms), statsd.TagsOf(opts...))
+}
+
+func (c *Client) TimeInMilliseconds(bucket string, ms, sampleRate float64, opts ...statsd.MetricOption) {
+	c.timing(bucket, ms, statsd.TagsOf(opts...))
+}
+
+func (c *Client) Timer(bucket string, opts ...statsd.MetricOption) func() {
+	start := time.Now()
+	return func() {
+		c.TimingDuration(bucket, time.Since(start), opts...)
+	}
+}
+
+func (c *Client) TimerWithTags(bucket string, tags ...string) func() {
+	return c.Timer(bucket, statsd.MetricTags(tags...))
+}
+
+func (c *Client) Instrument(bucket string, f func() error) error {
+	stop := c.Timer(bucket + ".duration")
+	err := f()
+	stop()
+
+	if err != nil {
+		c.count(bucket+".error", 1, nil)
+	} else {
+		c.count(bucket+".success", 1, nil)
+	}
+	return err
+}
+
+func (c *Client) Histogram(bucket string, value, sampleRate float64, opts ...statsd.MetricOption) {
+	c.observe(bucket, value, statsd.TagsOf(opts...))
+}
+
+func (c *Client) GaugeMulti(value float64, buckets ...string) {
+	for _, bucket := range buckets {
+		c.setGauge(bucket, value, nil)
+	}
+}
+
+func (c *Client) CountMulti(value float64, buckets ...string) {
+	for _, bucket := range buckets {
+		c.count(bucket, value, nil)
+	}
+}
+
+func (c *Client) TimingMulti(value float64, buckets ...string) {
+	for _, bucket := range buckets {
+		c.timing(bucket, value, nil)
+	}
+}
+
+func (c *Client) HistogramBatch(bucket string, values []float64, sampleRate float64, tags ...string) {
+	for _, value := range values {
+		c.observe(bucket, value, tags)
+	}
+}
+
+func (c *Client) Distribution(bucket string, value, sampleRate float64, opts ...statsd.MetricOption) {
+	c.observe(bucket, value, statsd.TagsOf(opts...))
+}
+
+func (c *Client) Observe(m statsd.Metric) {
+	switch m.Type {
+	case statsd.CountMetric:
+		c.count(m.Bucket, m.Value, m.Tags)
+	case statsd.GaugeMetric:
+		c.setGauge(m.Bucket, m.Value, m.Tags)
+	case statsd.TimingMetric:
+		c.timing(m.Bucket, m.Value, m.Tags)
+	case statsd.HistogramMetric, statsd.DistributionMetric:
+		c.observe(m.Bucket, m.Value, m.Tags)
+	}
+}
+
+// CountUnique isn't supported and discards the value.
+func (c *Client) CountUnique(bucket, value string, opts ...statsd.MetricOption) {}
+
+// CountUniqueApprox isn't supported and discards the value.
+func (c *Client) CountUniqueApprox(bucket, value string, opts ...statsd.MetricOption) {}
+
+// SendRaw isn't supported and discards the line.
+func (c *Client) SendRaw(line string) {}
+
+// WriteBytes isn't supported and discards the lines.
+func (c *Client) WriteBytes(p []byte) error {
+	return nil
+}
+
+// Flush does nothing, since metrics are recorded immediately.
+func (c *Client) Flush() error {
+	return nil
+}
+
+// FlushIfPending does nothing and reports that nothing was sent.
+func (c *Client) FlushIfPending() (bool, error) {
+	return false, nil
+}
+
+// FlushAndReset does nothing, since metrics are recorded immediately.
+func (c *Client) FlushAndReset() error {
+	return nil
+}
+
+// Drain returns nil, since nothing is buffered.
+func (c *Client) Drain() []byte {
+	return nil
+}
+
+// Stats returns zero counters.
+func (c *Client) Stats() statsd.Stats {
+	return statsd.Stats{}
+}
+
+// SnapshotStats returns zero counters.
+func (c *Client) SnapshotStats() statsd.Stats {
+	return statsd.Stats{}
+}
+
+// Config returns the client's prefix, default tags and error handler.
+func (c *Client) Config() statsd.Config {
+	return statsd.Config{
+		Prefix:       c.Prefix(),
+		PacketSize:   -1,
+		DefaultTags:  c.DefaultTags(),
+		ErrorHandler: c.errorHandler,
+	}
+}
+
+func (c *Client) DefaultTags() []string {
+	return append([]string(nil), c.tags...)
+}
+
+func (c *Client) Prefix() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.prefix
+}
+
+// SetPrefix changes the prefix of metrics recorded from now on. Metrics
+// already recorded keep their names.
+func (c *Client) SetPrefix(prefix string) error {
+	c.mu.Lock()
+	c.prefix = prefix
+	c.mu.Unlock()
+	return nil
+}
+
+// RecentLines returns nil, since no lines are formatted.
+func (c *Client) RecentLines() []string {
+	return nil
+}
+
+// SetDestination returns an error, since metrics are scraped rather than
+// sent.
+func (c *Client) SetDestination(addr string) error {
+	return errors.New("promstatsd: metrics are scraped, not sent")
+}
+
+// Ping always succeeds, since there's no server.
+func (c *Client) Ping(timeout time.Duration) error {
+	return nil
+}
+
+// FlushOnSignal does nothing, since nothing is buffered.
+func (c *Client) FlushOnSignal(sig ...os.Signal) {}
+
+// Reconnect does nothing, since there's no connection.
+func (c *Client) Reconnect() error {
+	return nil
+}
+
+// Close does nothing. Collectors stay registered.
+func (c *Client) Close() error {
+	return nil
+}
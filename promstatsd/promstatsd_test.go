@@ -0,0 +1,107 @@
+package promstatsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stvp/gostatsd"
+)
+
+// gather returns the gathered metric families by name.
+func gather(t *testing.T, registry *prometheus.Registry) map[string]*dto.MetricFamily {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	return byName
+}
+
+func TestMetricName(t *testing.T) {
+	c := New(prometheus.NewRegistry())
+	tests := map[string]string{
+		"api.requests":   "api_requests",
+		"cache-hit.rate": "cache_hit_rate",
+		"5xx":            "_5xx",
+		"ok:name":        "ok:name",
+	}
+	for bucket, expected := range tests {
+		if got := c.metricName(bucket); got != expected {
+			t.Errorf("Expected %#v but got %#v", expected, got)
+		}
+	}
+}
+
+func TestCounters(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	c := New(registry, WithPrefix("app"), WithTags("env:test"))
+
+	c.Count("requests", 2, 0.1, statsd.MetricTags("status:200"))
+	c.Increment("requests", statsd.MetricTags("status:200"))
+	c.Increment("requests", statsd.MetricTags("status:500"))
+	c.Count("requests", -1, 1, statsd.MetricTags("status:200"))
+
+	family := gather(t, registry)["app_requests_total"]
+	if family == nil || family.GetType() != dto.MetricType_COUNTER {
+		t.Fatalf("Expected a counter but got %v", family)
+	}
+	values := map[string]float64{}
+	for _, m := range family.GetMetric() {
+		labels := map[string]string{}
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["env"] != "test" {
+			t.Errorf("Expected the default tag as a label but got %v", labels)
+		}
+		values[labels["status"]] = m.GetCounter().GetValue()
+	}
+	if values["200"] != 3 || values["500"] != 1 {
+		t.Errorf("Expected 3 and 1 but got %v", values)
+	}
+}
+
+func TestGaugesAndHistograms(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	c := New(registry)
+
+	c.Gauge("queue.size", 10)
+	c.GaugeDelta("queue.size", -3)
+	c.TimingDuration("request", 250*time.Millisecond)
+	c.Histogram("payload", 1024, 1)
+
+	families := gather(t, registry)
+	if got := families["queue_size"].GetMetric()[0].GetGauge().GetValue(); got != 7 {
+		t.Errorf("Expected 7 but got %v", got)
+	}
+	timing := families["request_seconds"].GetMetric()[0].GetHistogram()
+	if timing.GetSampleCount() != 1 || timing.GetSampleSum() != 0.25 {
+		t.Errorf("Expected one 0.25s sample but got %v", timing)
+	}
+	if got := families["payload"].GetMetric()[0].GetHistogram().GetSampleSum(); got != 1024 {
+		t.Errorf("Expected 1024 but got %v", got)
+	}
+}
+
+func TestConflictingLabels(t *testing.T) {
+	var errs []error
+	registry := prometheus.NewRegistry()
+	c := New(registry, WithErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+
+	c.Increment("hits", statsd.MetricTags("a:1"))
+	c.Increment("hits", statsd.MetricTags("b:1"))
+	c.Increment("hits", statsd.MetricTags("b:2"))
+	if len(errs) != 1 {
+		t.Errorf("Expected the conflict to be reported once but got %v", errs)
+	}
+	if got := len(gather(t, registry)["hits_total"].GetMetric()); got != 1 {
+		t.Errorf("Expected only the first series but got %d", got)
+	}
+}
@@ -0,0 +1,68 @@
+package statsd
+
+import (
+	"bytes"
+	"sync"
+)
+
+// WithRecentLines keeps the last n metric lines recorded by the client in
+// memory, whether or not they were sent, so that RecentLines can show what
+// the process was sending before an incident. By default no lines are kept.
+func WithRecentLines(n int) Option {
+	return func(c *statsdClient) {
+		if n > 0 {
+			c.recent = &recentLines{lines: make([]string, 0, n)}
+		}
+	}
+}
+
+// recentLines is a ring buffer of metric lines.
+type recentLines struct {
+	sync.Mutex
+	lines []string
+
+	// Index of the oldest line once the buffer is full.
+	next int
+}
+
+// add keeps each line in p, which may hold several lines separated by
+// separator.
+func (r *recentLines) add(p, separator []byte) {
+	r.Lock()
+	defer r.Unlock()
+
+	for len(p) > 0 {
+		line := p
+		if i := bytes.Index(p, separator); i >= 0 {
+			line, p = p[:i], p[i+len(separator):]
+		} else {
+			p = nil
+		}
+
+		if len(r.lines) < cap(r.lines) {
+			r.lines = append(r.lines, string(line))
+			continue
+		}
+		r.lines[r.next] = string(line)
+		r.next = (r.next + 1) % len(r.lines)
+	}
+}
+
+// get returns a copy of the lines, oldest first.
+func (r *recentLines) get() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	lines := make([]string, 0, len(r.lines))
+	lines = append(lines, r.lines[r.next:]...)
+	return append(lines, r.lines[:r.next]...)
+}
+
+// RecentLines returns the last metric lines recorded by the client, oldest
+// first, if it was created WithRecentLines.
+func (c *statsdClient) RecentLines() []string {
+	if c.recent == nil {
+		return nil
+	}
+	return c.recent.get()
+}
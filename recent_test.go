@@ -0,0 +1,39 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecentLines(t *testing.T) {
+	client, err := New("statsd://localhost:8125", WithRecentLines(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if lines := client.RecentLines(); len(lines) != 0 {
+		t.Errorf("Expected no lines but got %#v", lines)
+	}
+
+	// Lines are kept whether or not they've been sent.
+	client.Count("a", 1, 1)
+	client.Flush()
+	client.Gauge("b", -1)
+	if lines, expected := client.RecentLines(), []string{"a:1|c", "b:0|g", "b:-1|g"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %#v but got %#v", expected, lines)
+	}
+
+	// Only the last lines are kept, oldest first.
+	client.SendRaw("c:1|c")
+	client.WriteBytes([]byte("d:1|c\ne:1|c"))
+	if lines, expected := client.RecentLines(), []string{"c:1|c", "d:1|c", "e:1|c"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %#v but got %#v", expected, lines)
+	}
+
+	client, _ = New("statsd://localhost:8125")
+	client.Count("a", 1, 1)
+	if lines := client.RecentLines(); lines != nil {
+		t.Errorf("Expected no lines but got %#v", lines)
+	}
+}
@@ -0,0 +1,202 @@
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// Backoff configures how a client created WithReconnectBackoff reconnects
+// after a write fails. The zero value of each field means the default.
+type Backoff struct {
+	// Delay before the first attempt. The default is 100ms.
+	Initial time.Duration
+
+	// Largest delay between attempts. The default is 30s.
+	Max time.Duration
+
+	// Factor the delay grows by after each failed attempt. The default is 2.
+	Multiplier float64
+
+	// Fraction of each delay, between 0 and 1, that's randomly added or
+	// removed so that many clients don't reconnect in lockstep. The default
+	// is no jitter.
+	Jitter float64
+
+	// Number of attempts after which the client gives up and drops all
+	// further stats. The default is to keep trying forever.
+	MaxAttempts int
+
+	// Number of packets kept while reconnecting, which are sent once the
+	// client has reconnected. Packets beyond this are dropped and counted in
+	// Stats. The default is to drop every packet until reconnected.
+	BufferPackets int
+}
+
+// WithReconnectBackoff reconnects to the statsd server in the background when
+// writing to it fails, retrying with exponential backoff. Stats recorded in
+// the meantime are kept or dropped as configured by backoff. If the client
+// gives up, an error wrapping ErrDial is passed to the error handler.
+func WithReconnectBackoff(backoff Backoff) Option {
+	if backoff.Initial <= 0 {
+		backoff.Initial = 100 * time.Millisecond
+	}
+	if backoff.Max <= 0 {
+		backoff.Max = 30 * time.Second
+	}
+	if backoff.Multiplier < 1 {
+		backoff.Multiplier = 2
+	}
+	return func(c *statsdClient) {
+		c.reconnector = &reconnector{backoff: backoff}
+	}
+}
+
+type reconnectState int
+
+const (
+	connected reconnectState = iota
+	reconnecting
+	gaveUp
+)
+
+// reconnector tracks a client's background reconnection. It's guarded by the
+// client's buffer lock.
+type reconnector struct {
+	backoff Backoff
+	state   reconnectState
+	held    [][]byte
+}
+
+// delay returns the delay before the given attempt, counting from 1.
+func (b Backoff) delay(attempt int) time.Duration {
+	delay := float64(b.Initial)
+	for i := 1; i < attempt && delay < float64(b.Max); i++ {
+		delay *= b.Multiplier
+	}
+	delay = min(delay, float64(b.Max))
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// disconnected reports whether packets should be held instead of written. It
+// must be called with the buffer locked.
+func (c *statsdClient) disconnected() bool {
+	return c.reconnector != nil && c.reconnector.state != connected
+}
+
+// holdPacket keeps a copy of packet to send once reconnected, or spills or
+// drops it if there's no room. It reports whether the packet was kept. It
+// must be called with the buffer locked.
+func (c *statsdClient) holdPacket(packet []byte) bool {
+	r := c.reconnector
+	if r.state == gaveUp || len(r.held) >= r.backoff.BufferPackets {
+		if c.spill != nil {
+			c.spillPacket(packet)
+			return true
+		}
+		c.stats.droppedPackets.Add(1)
+		return false
+	}
+	r.held = append(r.held, bytes.Clone(packet))
+	return true
+}
+
+// writeFailed starts reconnecting after a failed write, if the client
+// reconnects automatically, and holds the packet that failed, if known. It
+// reports whether the packet was kept to be sent later, by being held or
+// spilled. It must be called with the buffer locked.
+func (c *statsdClient) writeFailed(err error, packet []byte) bool {
+	if errors.Is(err, io.ErrShortWrite) {
+		return false
+	}
+	r := c.reconnector
+	if r == nil {
+		if c.spill != nil && c.shouldSpill() && packet != nil {
+			c.spillPacket(packet)
+			return true
+		}
+		return false
+	}
+	if r.state == connected {
+		r.state = reconnecting
+		go c.reconnectLoop()
+	}
+	if packet != nil {
+		return c.holdPacket(packet)
+	}
+	return false
+}
+
+// reconnectLoop tries to reconnect until it succeeds, the client gives up or
+// the client is closed.
+func (c *statsdClient) reconnectLoop() {
+	r := c.reconnector
+	for attempt := 1; ; attempt++ {
+		timer := c.clock.NewTimer(r.backoff.delay(attempt))
+		select {
+		case <-timer.C():
+		case <-c.closed:
+			timer.Stop()
+			return
+		}
+
+		// Unlike Reconnect, the old connection's Close error is ignored, since
+		// it's already broken.
+		conn, err := c.dial()
+		if err == nil {
+			c.handleError(c.sendHeld(conn))
+			return
+		}
+
+		if r.backoff.MaxAttempts > 0 && attempt >= r.backoff.MaxAttempts {
+			c.buffer.Lock()
+			r.state = gaveUp
+			c.stats.droppedPackets.Add(uint64(len(r.held)))
+			r.held = nil
+			c.unlockBuffer()
+
+			c.handleError(fmt.Errorf("statsd: gave up reconnecting after %d attempts: %w", attempt, err))
+			return
+		}
+	}
+}
+
+// sendHeld replaces the client's connection with conn and sends the packets
+// held while it was reconnecting. If a write fails, the rest are held again.
+// If the client was closed while conn was being dialed, conn is closed
+// instead, since Close has already closed the client's connection.
+func (c *statsdClient) sendHeld(conn net.Conn) error {
+	c.buffer.Lock()
+	defer c.unlockBuffer()
+
+	select {
+	case <-c.closed:
+		return conn.Close()
+	default:
+	}
+	c.conn.Close()
+	c.conn = conn
+
+	r := c.reconnector
+	held := r.held
+	r.held = nil
+	r.state = connected
+	for i, packet := range held {
+		if err := c.write(packet); err != nil {
+			c.writeFailed(err, nil)
+			for _, rest := range held[i:] {
+				c.holdPacket(rest)
+			}
+			return err
+		}
+	}
+	c.sendSpilled()
+	return nil
+}
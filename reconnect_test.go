@@ -0,0 +1,134 @@
+package statsd
+
+import (
+	"github.com/stvp/go-udp-testing"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	udp.SetAddr(":8125")
+	clock := newFakeClock()
+	client, _ := New("statsd://localhost:8125", withClock(clock), WithReconnectBackoff(Backoff{BufferPackets: 1}))
+	defer client.Close()
+
+	// Break the connection so that the next write fails.
+	c := client.(*statsdClient)
+	c.conn.Close()
+
+	client.Count("a", 1, 1)
+	client.Count("b", 1, 1)
+	client.Flush()
+
+	// Only one packet is kept while reconnecting.
+	client.Count("c", 1, 1)
+	client.Flush()
+	if dropped := client.Stats().DroppedPackets; dropped != 1 {
+		t.Errorf("Expected 1 dropped packet but got %d", dropped)
+	}
+
+	// The kept packet is sent once reconnected.
+	clock.WaitForTimers(1)
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:1|c", func() {
+		clock.Advance(100 * time.Millisecond)
+	})
+	udp.ShouldReceiveOnly(t, "d:1|c", func() {
+		client.Count("d", 1, 1)
+		client.Flush()
+	})
+}
+
+func TestReconnectBackoffGiveUp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 10)
+	clock := newFakeClock()
+	client, err := New("statsd://"+listener.Addr().String(), WithNetwork("tcp"), withClock(clock),
+		WithErrorHandler(func(err error) { errs <- err }),
+		WithReconnectBackoff(Backoff{MaxAttempts: 2, BufferPackets: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	listener.Close()
+	client.(*statsdClient).conn.Close()
+	client.Count("a", 1, 1)
+	client.Flush()
+
+	// The delay doubles after each failed attempt.
+	clock.WaitForTimers(1)
+	clock.Advance(100 * time.Millisecond)
+	clock.WaitForTimers(1)
+	clock.Advance(200 * time.Millisecond)
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case err := <-errs:
+			if !strings.Contains(err.Error(), "gave up reconnecting after 2 attempts") {
+				continue
+			}
+		case <-timeout:
+			t.Fatal("Expected the client to give up reconnecting")
+		}
+		break
+	}
+
+	// The kept packet and any later ones are dropped.
+	client.Count("b", 1, 1)
+	client.Flush()
+	if dropped := client.Stats().DroppedPackets; dropped != 2 {
+		t.Errorf("Expected 2 dropped packets but got %d", dropped)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 2}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if delay := backoff.delay(attempt + 1); delay != expected {
+			t.Errorf("Expected attempt %d to wait %s but got %s", attempt+1, expected, delay)
+		}
+	}
+
+	backoff.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := backoff.delay(1); delay < 500*time.Millisecond || delay > 1500*time.Millisecond {
+			t.Errorf("Expected a delay between 500ms and 1.5s but got %s", delay)
+		}
+	}
+}
+
+// closeConn is a net.Conn that records whether it was closed.
+type closeConn struct {
+	net.Conn
+	closed bool
+}
+
+func (c *closeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestReconnectAfterClose(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithReconnectBackoff(Backoff{BufferPackets: 1}))
+	client.Close()
+
+	// A connection dialed while the client was closing is closed rather than
+	// installed.
+	c := client.(*statsdClient)
+	old := c.conn
+	conn := &closeConn{}
+	if err := c.sendHeld(conn); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.closed || c.conn != old {
+		t.Errorf("Expected the new connection to be closed and not used")
+	}
+}
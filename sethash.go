@@ -0,0 +1,44 @@
+package statsd
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// WithHashedSetValues sends a hash of each CountUnique value instead of the
+// value itself, to keep lines short for long values such as URLs. The server
+// still counts distinct values, since equal values have equal hashes, but two
+// different values that hash the same are counted once. With the default
+// 64-bit FNV-1a hash, used if hash is nil, that's unlikely until a bucket has
+// billions of values. Values are hashed before they're sanitized, and hashes
+// are sanitized like any other value. Values counted WithExactUniques aren't
+// sent, so they aren't hashed.
+func WithHashedSetValues(hash func(value string) string) Option {
+	if hash == nil {
+		hash = FNVSetValue
+	}
+	return func(c *statsdClient) {
+		c.hashSetValues = hash
+	}
+}
+
+// FNVSetValue returns the 64-bit FNV-1a hash of value as 16 hex digits. It's
+// the default hash used by WithHashedSetValues.
+func FNVSetValue(value string) string {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	hash := strconv.FormatUint(h.Sum64(), 16)
+	for len(hash) < 16 {
+		hash = "0" + hash
+	}
+	return hash
+}
+
+// setValue returns a set value as it's sent, hashed if the client was created
+// WithHashedSetValues, or nil if nothing is left after sanitizing it.
+func (c *statsdClient) setValue(value string) []byte {
+	if c.hashSetValues != nil {
+		value = c.hashSetValues(value)
+	}
+	return cleanSetValue(value)
+}
@@ -0,0 +1,218 @@
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Number of writes in a row that have to fail before packets are spilled by a
+// client that doesn't reconnect automatically, so that packets are only
+// spilled during an outage rather than after every failed write.
+const spillAfterFailures = 3
+
+// WithSpillFile keeps packets that can't be sent during an outage in a file at
+// path instead of dropping them, and sends them once a write to the server
+// succeeds again, so that metrics recorded during the outage aren't lost.
+// Without WithReconnectBackoff, packets are spilled once 3 writes in a row
+// have failed, and those that fail before then are dropped as usual. With it,
+// packets are held in memory while reconnecting as usual, and only those that
+// don't fit are spilled.
+//
+// The file holds one metric line after another, each followed by the client's
+// line separator, and is limited to maxBytes. When it's full, the oldest lines
+// are dropped to make room and counted in Stats. Lines are sent from the file
+// after the next successful flush, before it returns, and the file is
+// removed. The file is written and read after the buffer is unlocked, so that
+// recording metrics doesn't wait for it. A file left behind by an earlier
+// process is sent the same way, so it should only be shared by clients with
+// the same line separator sending to the same server.
+func WithSpillFile(path string, maxBytes int64) Option {
+	return func(c *statsdClient) {
+		c.spill = &spillFile{path: path, maxBytes: maxBytes, after: spillAfterFailures, pending: true}
+	}
+}
+
+// spillFile is a file of metric lines waiting to be sent.
+type spillFile struct {
+	path     string
+	maxBytes int64
+
+	// Number of writes that have failed in a row, and the number after which
+	// packets are spilled. failures is guarded by the client's buffer lock.
+	failures int
+	after    int
+
+	// Set when there are lines queued or the file should be sent, so that
+	// unlocking the buffer can check without taking the lock below.
+	work atomic.Bool
+
+	// Guards the file and the fields below. It's never held while waiting
+	// for the buffer lock.
+	sync.Mutex
+
+	// Whether the file might have lines in it.
+	pending bool
+
+	// Lines waiting to be added to the file.
+	queued [][]byte
+
+	// Whether a write has succeeded since the file was last sent.
+	resend bool
+}
+
+// append adds lines to the end of the file, each followed by separator,
+// dropping the oldest lines if it would grow past its limit. It returns the
+// number of lines dropped. It must be called with the spill file locked.
+func (s *spillFile) append(lines [][]byte, separator []byte) (int, error) {
+	var data []byte
+	for _, line := range lines {
+		data = append(append(data, line...), separator...)
+	}
+	s.pending = true
+
+	var size int64
+	if info, err := os.Stat(s.path); err == nil {
+		size = info.Size()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	if size+int64(len(data)) <= s.maxBytes {
+		f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return 0, err
+		}
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return 0, err
+	}
+
+	old, err := os.ReadFile(s.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	data = append(old, data...)
+	dropped := 0
+	for len(data) > 0 && int64(len(data)) > s.maxBytes {
+		i := bytes.Index(data, separator)
+		if i < 0 {
+			data = nil
+		} else {
+			data = data[i+len(separator):]
+		}
+		dropped++
+	}
+	return dropped, os.WriteFile(s.path, data, 0o600)
+}
+
+// take removes the file and returns the lines that were in it, which are each
+// followed by separator. It must be called with the spill file locked.
+func (s *spillFile) take(separator []byte) ([][]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.pending = false
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(s.path); err != nil {
+		return nil, err
+	}
+	s.pending = false
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return bytes.Split(bytes.TrimSuffix(data, separator), separator), nil
+}
+
+// shouldSpill counts a failed write by a client that doesn't reconnect
+// automatically, and reports whether enough have failed in a row for its
+// packet to be spilled. It must be called with the buffer locked.
+func (c *statsdClient) shouldSpill() bool {
+	c.spill.failures++
+	return c.spill.failures >= c.spill.after
+}
+
+// spillPacket queues the lines in a packet that couldn't be sent to be written
+// to the spill file. It must be called with the buffer locked.
+func (c *statsdClient) spillPacket(packet []byte) {
+	packet = bytes.TrimSuffix(packet, c.packetEnd())
+	c.spillLines(bytes.Split(packet, c.buffer.lineSeparator()))
+}
+
+// spillLines queues copies of lines to be written to the spill file once the
+// buffer is unlocked. It must be called with the buffer locked.
+func (c *statsdClient) spillLines(lines [][]byte) {
+	s := c.spill
+	s.Lock()
+	for _, line := range lines {
+		s.queued = append(s.queued, bytes.Clone(line))
+	}
+	s.Unlock()
+	s.work.Store(true)
+	c.stats.spilled.Add(uint64(len(lines)))
+}
+
+// sendSpilled is called after a write has succeeded, to have the lines in the
+// spill file sent once the buffer is unlocked, if there are any. It must be
+// called with the buffer locked.
+func (c *statsdClient) sendSpilled() {
+	s := c.spill
+	if s == nil {
+		return
+	}
+	s.failures = 0
+	s.Lock()
+	if s.pending {
+		s.resend = true
+		s.work.Store(true)
+	}
+	s.Unlock()
+}
+
+// writeSpill writes the lines queued by spillLines to the spill file, and then
+// sends the lines in the file if sendSpilled asked for it. Lines that can't be
+// sent are spilled again. It's called by unlockBuffer, and must be called
+// with the buffer unlocked.
+func (c *statsdClient) writeSpill() {
+	s := c.spill
+	separator := c.buffer.lineSeparator()
+
+	s.Lock()
+	s.work.Store(false)
+	queued := s.queued
+	s.queued = nil
+	var err error
+	if len(queued) > 0 {
+		var dropped int
+		dropped, err = s.append(queued, separator)
+		c.stats.spillDropped.Add(uint64(dropped))
+	}
+	var lines [][]byte
+	if s.resend {
+		s.resend = false
+		var takeErr error
+		if lines, takeErr = s.take(separator); err == nil {
+			err = takeErr
+		}
+	}
+	s.Unlock()
+	c.handleError(err)
+
+	if len(lines) == 0 {
+		return
+	}
+	c.buffer.Lock()
+	sent, err := c.writeGrouped(lines, separator, c.packetEnd(), c.PacketSize)
+	if err != nil {
+		c.spillLines(lines[sent:])
+		c.handleLockedError(err)
+	}
+	c.unlockBuffer()
+}
@@ -0,0 +1,121 @@
+package statsd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// outageConn is a net.Conn whose writes fail while down is set.
+type outageConn struct {
+	net.Conn
+	down    bool
+	packets []string
+}
+
+func (c *outageConn) Write(p []byte) (int, error) {
+	if c.down {
+		return 0, errors.New("connection refused")
+	}
+	c.packets = append(c.packets, string(p))
+	return len(p), nil
+}
+
+func TestSpillFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statsd.spill")
+	conn := &outageConn{down: true}
+	client := newStatsdClient(512, []Option{WithSpillFile(path, 17)})
+	client.conn = conn
+
+	// Packets are dropped until enough writes have failed in a row.
+	for i := 1; i < spillAfterFailures; i++ {
+		client.Count("x", 1, 1)
+		client.Flush()
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected nothing to be spilled yet but got %#v", err)
+	}
+
+	// Then failed packets are spilled, dropping the oldest lines beyond the
+	// limit.
+	client.Count("a", 1, 1)
+	client.Count("b", 1, 1)
+	client.Flush()
+	client.Count("c", 1, 1)
+	client.Flush()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "b:1|c\nc:1|c\n" {
+		t.Errorf("Expected the newest lines to be kept but got %#v", string(data))
+	}
+	if stats := client.Stats(); stats.Spilled != 3 || stats.SpillDropped != 1 {
+		t.Errorf("Expected 3 spilled and 1 dropped but got %#v", stats)
+	}
+
+	// Once a write succeeds, the spilled lines follow it and the file is
+	// removed.
+	conn.down = false
+	client.Count("d", 1, 1)
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"d:1|c", "b:1|c\nc:1|c"}
+	if len(conn.packets) != 2 || conn.packets[0] != expected[0] || conn.packets[1] != expected[1] {
+		t.Errorf("Expected %#v but got %#v", expected, conn.packets)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the spill file to be removed but got %#v", err)
+	}
+
+	// A single failure after a success isn't spilled.
+	conn.down = true
+	client.Count("e", 1, 1)
+	client.Flush()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected nothing to be spilled but got %#v", err)
+	}
+}
+
+func TestSpillFileSeparator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statsd.spill")
+	conn := &outageConn{down: true}
+	client := newStatsdClient(512, []Option{WithSpillFile(path, 1024), WithLineSeparator(',')})
+	client.conn = conn
+	client.spill.after = 1
+
+	// Lines are stored with the client's separator, since they may contain
+	// newlines.
+	client.Count("a\nb", 1, 1)
+	client.Count("c", 1, 1)
+	client.Flush()
+	if data, _ := os.ReadFile(path); string(data) != "a\nb:1|c,c:1|c," {
+		t.Errorf("Expected lines followed by the separator but got %q", data)
+	}
+
+	conn.down = false
+	client.Count("d", 1, 1)
+	client.Flush()
+	if len(conn.packets) != 2 || conn.packets[1] != "a\nb:1|c,c:1|c" {
+		t.Errorf("Expected the spilled lines unchanged but got %#v", conn.packets)
+	}
+}
+
+func TestSpillFileLeftBehind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statsd.spill")
+	if err := os.WriteFile(path, []byte("old:1|c\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conn := &outageConn{}
+	client := newStatsdClient(512, []Option{WithSpillFile(path, 1024)})
+	client.conn = conn
+
+	client.Count("new", 1, 1)
+	client.Flush()
+	if len(conn.packets) != 2 || conn.packets[1] != "old:1|c" {
+		t.Errorf("Expected the old line to be sent but got %#v", conn.packets)
+	}
+}
@@ -0,0 +1,152 @@
+package statsd
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Stats are counters of what a client has done since it was created.
+type Stats struct {
+	// Number of metrics sent or added to the buffer.
+	Sent uint64
+
+	// Number of metrics dropped because of their sample rate.
+	SampledOut uint64
+
+	// Number of metrics sent without their tags, and number dropped, because
+	// they were longer than the maximum line length.
+	TagsDropped uint64
+	TooLong     uint64
+
+	// Number of metrics dropped by the filter or the WithBeforeSend hook.
+	Filtered uint64
+
+	// Number of metrics dropped because they contained the line separator.
+	Invalid uint64
+
+	// Number of packets dropped while reconnecting, or because they were too
+	// big for the network and couldn't be split.
+	DroppedPackets uint64
+
+	// Number of negative counts dropped by RejectNegativeCounts.
+	NegativeCounts uint64
+
+	// Number of metric lines longer than the packet size, which are sent
+	// whole in a packet of their own.
+	Oversized uint64
+
+	// Largest number of bytes held in the buffer at once, and number of
+	// flushes forced by the buffer filling up, as opposed to flushes made by
+	// Flush or the flush interval. Together they show whether PacketSize
+	// suits the metrics being sent.
+	BufferHighWater uint64
+	ForcedFlushes   uint64
+
+	// Number of times the size of a packet was halved because it was too
+	// big for the network.
+	PacketSizeReductions uint64
+
+	// Number of metric lines written to the spill file, and number dropped
+	// from it because it was full.
+	Spilled      uint64
+	SpillDropped uint64
+}
+
+// clientStats holds the counters behind Stats. They're updated atomically so
+// that recording metrics doesn't need another lock.
+type clientStats struct {
+	sent        atomic.Uint64
+	sampledOut  atomic.Uint64
+	tagsDropped atomic.Uint64
+	tooLong     atomic.Uint64
+	filtered    atomic.Uint64
+	invalid     atomic.Uint64
+
+	droppedPackets atomic.Uint64
+	negativeCounts atomic.Uint64
+	oversized      atomic.Uint64
+
+	bufferHighWater atomic.Uint64
+	forcedFlushes   atomic.Uint64
+
+	packetSizeReductions atomic.Uint64
+	spilled              atomic.Uint64
+	spillDropped         atomic.Uint64
+
+	// The high-water mark since the last snapshot, and the totals at the
+	// last snapshot, which snapshotLock guards.
+	intervalHighWater atomic.Uint64
+	snapshotLock      sync.Mutex
+	lastSnapshot      Stats
+}
+
+// updateHighWater raises the buffer high-water marks to size. It must be
+// called with the buffer locked.
+func (s *clientStats) updateHighWater(size int) {
+	if uint64(size) > s.bufferHighWater.Load() {
+		s.bufferHighWater.Store(uint64(size))
+	}
+	// SnapshotStats resets the interval mark without the buffer lock.
+	for {
+		old := s.intervalHighWater.Load()
+		if uint64(size) <= old || s.intervalHighWater.CompareAndSwap(old, uint64(size)) {
+			return
+		}
+	}
+}
+
+// Stats returns the client's counters.
+func (c *statsdClient) Stats() Stats {
+	return Stats{
+		Sent:        c.stats.sent.Load(),
+		SampledOut:  c.stats.sampledOut.Load(),
+		TagsDropped: c.stats.tagsDropped.Load(),
+		TooLong:     c.stats.tooLong.Load(),
+		Filtered:    c.stats.filtered.Load(),
+		Invalid:     c.stats.invalid.Load(),
+
+		DroppedPackets: c.stats.droppedPackets.Load(),
+		NegativeCounts: c.stats.negativeCounts.Load(),
+		Oversized:      c.stats.oversized.Load(),
+
+		BufferHighWater: c.stats.bufferHighWater.Load(),
+		ForcedFlushes:   c.stats.forcedFlushes.Load(),
+
+		PacketSizeReductions: c.stats.packetSizeReductions.Load(),
+		Spilled:              c.stats.spilled.Load(),
+		SpillDropped:         c.stats.spillDropped.Load(),
+	}
+}
+
+// SnapshotStats returns how much the client's counters have grown since the
+// last call to SnapshotStats, or since the client was created, so that they
+// can be reported per interval instead of as running totals. Concurrent
+// snapshots never count anything twice. BufferHighWater is the largest the
+// buffer has been since the last snapshot. Stats still returns the totals.
+func (c *statsdClient) SnapshotStats() Stats {
+	c.stats.snapshotLock.Lock()
+	defer c.stats.snapshotLock.Unlock()
+
+	total := c.Stats()
+	last := c.stats.lastSnapshot
+	c.stats.lastSnapshot = total
+	return Stats{
+		Sent:        total.Sent - last.Sent,
+		SampledOut:  total.SampledOut - last.SampledOut,
+		TagsDropped: total.TagsDropped - last.TagsDropped,
+		TooLong:     total.TooLong - last.TooLong,
+		Filtered:    total.Filtered - last.Filtered,
+		Invalid:     total.Invalid - last.Invalid,
+
+		DroppedPackets: total.DroppedPackets - last.DroppedPackets,
+		NegativeCounts: total.NegativeCounts - last.NegativeCounts,
+		Oversized:      total.Oversized - last.Oversized,
+
+		BufferHighWater: c.stats.intervalHighWater.Swap(0),
+		ForcedFlushes:   total.ForcedFlushes - last.ForcedFlushes,
+
+		PacketSizeReductions: total.PacketSizeReductions - last.PacketSizeReductions,
+		Spilled:              total.Spilled - last.Spilled,
+		SpillDropped:         total.SpillDropped - last.SpillDropped,
+	}
+}
@@ -0,0 +1,224 @@
+package statsd
+
+import (
+	"github.com/stvp/go-udp-testing"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStatsSampledOut(t *testing.T) {
+	client := goodClient("", 512)
+	for i := 0; i < 1000; i++ {
+		client.Count("a", 1, 0.1)
+	}
+	client.Count("b", 1, 1)
+	client.Count("c", 1, 0)
+
+	stats := client.Stats()
+	if stats.Sent+stats.SampledOut != 1002 {
+		t.Errorf("Expected 1002 metrics in total but got %#v", stats)
+	}
+	// About 900 of the sampled counts, and the count with a rate of 0,
+	// should be dropped.
+	if stats.SampledOut < 800 || stats.SampledOut > 990 {
+		t.Errorf("Expected about 901 metrics to be sampled out but got %d", stats.SampledOut)
+	}
+}
+
+func TestHistogramAndDistributionSampling(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithUnbuffered())
+
+	record := map[string]func(string, float64, float64, ...MetricOption){
+		"h": client.Histogram,
+		"d": client.Distribution,
+	}
+	for kind, f := range record {
+		udp.ShouldReceive(t, "a:3|"+kind+"|@0.5", func() {
+			for {
+				before := client.Stats().Sent
+				f("a", 3, 0.5)
+				if client.Stats().Sent > before {
+					break
+				}
+			}
+		})
+	}
+
+	// Send elsewhere so that the test server isn't flooded.
+	client, _ = New("statsd://localhost:8126")
+	for i := 0; i < 1000; i++ {
+		client.Histogram("a", 1, 0.5)
+		client.Distribution("b", 1, 0.5)
+	}
+	stats := client.Stats()
+	sent, sampledOut := stats.Sent, stats.SampledOut
+	if sent+sampledOut != 2000 {
+		t.Errorf("Expected 2000 metrics in total but got %d sent and %d sampled out", sent, sampledOut)
+	}
+	if sampledOut < 850 || sampledOut > 1150 {
+		t.Errorf("Expected about 1000 metrics to be sampled out but got %d", sampledOut)
+	}
+}
+
+func TestMaxLineLength(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithMaxLineLength(16), WithTags("env:test"))
+
+	expected := "a:1|c|#env:test\nbucket:1|c\na:0|g|#env:test\na:-1|g|#env:test"
+	udp.ShouldReceiveOnly(t, expected, func() {
+		client.Count("a", 1, 1)
+		client.Count("bucket", 1, 1)
+		client.Count("long.bucket.x", 1, 1)
+		client.Gauge("a", -1)
+		client.Flush()
+	})
+
+	stats := client.Stats()
+	if stats.Sent != 3 || stats.TagsDropped != 1 || stats.TooLong != 1 {
+		t.Errorf("Expected 3 sent, 1 without tags and 1 too long but got %#v", stats)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	udp.SetAddr(":8125")
+	allowed := map[string]bool{"requests": true, "errors": true}
+	client, _ := New("statsd://localhost:8125/app", WithFilter(func(bucket string) bool {
+		return allowed[bucket]
+	}))
+
+	udp.ShouldReceiveOnly(t, "app.requests:1|c\napp.errors:1|c", func() {
+		client.Count("requests", 1, 1)
+		client.Count("reqeusts", 1, 1)
+		client.Count("errors", 1, 1)
+		client.Flush()
+	})
+
+	if stats := client.Stats(); stats.Sent != 2 || stats.Filtered != 1 {
+		t.Errorf("Expected 2 sent and 1 filtered but got %#v", stats)
+	}
+}
+
+func TestBeforeSend(t *testing.T) {
+	udp.SetAddr(":8125")
+	var seen []string
+	client, _ := New("statsd://localhost:8125/app", WithBeforeSend(func(bucket, value, kind string, rate float64, tags []string) bool {
+		seen = append(seen, bucket+" "+value+" "+kind+" "+strconv.FormatFloat(rate, 'f', -1, 64)+" "+strings.Join(tags, ","))
+		return bucket != "debug"
+	}))
+
+	udp.ShouldReceiveOnly(t, "app.requests:2|c|#route:/\napp.latency:5|ms", func() {
+		client.Count("requests", 2, 1, MetricTags("route:/"))
+		client.Gauge("debug", 1)
+		client.Timing("latency", 5)
+		client.Flush()
+	})
+
+	expected := []string{"requests 2 c 1 route:/", "debug 1 g 1 ", "latency 5 ms 1 "}
+	if strings.Join(seen, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %#v but got %#v", expected, seen)
+	}
+	if stats := client.Stats(); stats.Sent != 2 || stats.Filtered != 1 {
+		t.Errorf("Expected 2 sent and 1 filtered but got %#v", stats)
+	}
+}
+
+func TestLineSeparator(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithLineSeparator(','))
+
+	udp.ShouldReceiveOnly(t, "a:1|c,b:0|g,b:-2|g", func() {
+		client.Count("a", 1, 1)
+		client.Count("x,y", 1, 1)
+		client.CountUnique("c", "d", MetricTags("k:v,w"))
+		client.Gauge("b", -2)
+		client.Flush()
+	})
+	if stats := client.Stats(); stats.Sent != 2 || stats.Invalid != 2 {
+		t.Errorf("Expected 2 sent and 2 invalid but got %#v", stats)
+	}
+
+	// The default separator is a newline.
+	client, _ = New("statsd://localhost:8125")
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client.Count("a\nb", 1, 1)
+		client.Count("a", 1, 1)
+		client.Flush()
+	})
+}
+
+func TestStatsOversized(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 100)
+
+	var tags []string
+	for i := 0; i < 50; i++ {
+		tags = append(tags, "tag"+strconv.Itoa(i)+":value")
+	}
+	line := "a:1|c|#" + strings.Join(tags, ",")
+
+	// A line longer than the packet size is sent whole in its own packet,
+	// without splitting any of its tags, after what was already buffered.
+	client.Count("b", 1, 1)
+	udp.ShouldReceiveOnly(t, "b:1|c", func() {
+		client.Count("a", 1, 1, MetricTags(tags...))
+	})
+	udp.ShouldReceiveOnly(t, line, func() {
+		client.Count("a", 1, 1, MetricTags(tags...))
+	})
+	udp.ShouldReceiveOnly(t, "c:1|c", func() {
+		client.Count("c", 1, 1)
+		client.Flush()
+	})
+	if oversized := client.Stats().Oversized; oversized != 2 {
+		t.Errorf("Expected 2 oversized lines but got %d", oversized)
+	}
+}
+
+func TestStatsBufferHighWater(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 12)
+
+	// "a:1|c\nb:1|c" fills the buffer, so "c:1|c" forces a flush.
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:1|c", func() {
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 1)
+		client.Count("c", 1, 1)
+	})
+	udp.ShouldReceiveOnly(t, "c:1|c", func() {
+		client.Flush()
+	})
+
+	stats := client.Stats()
+	if stats.BufferHighWater != 11 {
+		t.Errorf("Expected a high-water mark of 11 but got %d", stats.BufferHighWater)
+	}
+	if stats.ForcedFlushes != 1 {
+		t.Errorf("Expected 1 forced flush but got %d", stats.ForcedFlushes)
+	}
+}
+
+func TestSnapshotStats(t *testing.T) {
+	client := goodClient("", 512)
+	client.Count("a", 1, 1)
+	client.Count("b", 1, 1)
+
+	if snapshot := client.SnapshotStats(); snapshot.Sent != 2 || snapshot.BufferHighWater != 11 {
+		t.Errorf("Expected 2 sent and a high-water mark of 11 but got %#v", snapshot)
+	}
+	client.Flush()
+	client.Count("c", 1, 1)
+
+	if snapshot := client.SnapshotStats(); snapshot.Sent != 1 || snapshot.BufferHighWater != 5 {
+		t.Errorf("Expected 1 sent and a high-water mark of 5 but got %#v", snapshot)
+	}
+	if snapshot := client.SnapshotStats(); snapshot != (Stats{}) {
+		t.Errorf("Expected nothing since the last snapshot but got %#v", snapshot)
+	}
+
+	// Stats still returns the totals.
+	if stats := client.Stats(); stats.Sent != 3 || stats.BufferHighWater != 11 {
+		t.Errorf("Expected 3 sent and a high-water mark of 11 but got %#v", stats)
+	}
+}
@@ -1,6 +1,7 @@
 package statsd
 
 import (
+	"os"
 	"time"
 )
 
@@ -8,8 +9,8 @@ var (
 	client Client
 )
 
-func Setup(statsdUrl string, packetSize int) (err error) {
-	client, err = NewWithPacketSize(statsdUrl, packetSize)
+func Setup(statsdUrl string, packetSize int, options ...Option) (err error) {
+	client, err = NewWithPacketSize(statsdUrl, packetSize, options...)
 	if err != nil {
 		return err
 	}
@@ -23,32 +24,226 @@ func Flush() {
 	}
 }
 
-func Count(bucket string, value float64, sampleRate float64) {
+func FlushIfPending() (bool, error) {
 	if client != nil {
-		client.Flush()
+		return client.FlushIfPending()
 	}
+	return false, nil
 }
 
-func Gauge(bucket string, value float64) {
+func FlushAndReset() {
 	if client != nil {
-		client.Gauge(bucket, value)
+		client.FlushAndReset()
 	}
 }
 
-func Timing(bucket string, value float64) {
+func Close() error {
 	if client != nil {
-		client.Timing(bucket, value)
+		return client.Close()
 	}
+	return nil
 }
 
-func TimingDuration(bucket string, duration time.Duration) {
+func Reconnect() error {
 	if client != nil {
-		client.TimingDuration(bucket, duration)
+		return client.Reconnect()
 	}
+	return nil
 }
 
-func CountUnique(bucket string, value string) {
+func FlushOnSignal(sig ...os.Signal) {
 	if client != nil {
-		client.CountUnique(bucket, value)
+		client.FlushOnSignal(sig...)
 	}
 }
+
+func Count(bucket string, value float64, sampleRate float64, opts ...MetricOption) {
+	if client != nil {
+		client.Count(bucket, value, sampleRate, opts...)
+	}
+}
+
+func Increment(bucket string, opts ...MetricOption) {
+	if client != nil {
+		client.Increment(bucket, opts...)
+	}
+}
+
+func CountRate(bucket string, value float64, opts ...MetricOption) {
+	if client != nil {
+		client.CountRate(bucket, value, opts...)
+	}
+}
+
+func Add(bucket string, value float64, opts ...MetricOption) {
+	if client != nil {
+		client.Add(bucket, value, opts...)
+	}
+}
+
+func Gauge(bucket string, value float64, opts ...MetricOption) {
+	if client != nil {
+		client.Gauge(bucket, value, opts...)
+	}
+}
+
+func GaugeBool(bucket string, value bool, opts ...MetricOption) {
+	if client != nil {
+		client.GaugeBool(bucket, value, opts...)
+	}
+}
+
+func GaugeState(bucket string, state int, opts ...MetricOption) {
+	if client != nil {
+		client.GaugeState(bucket, state, opts...)
+	}
+}
+
+func GaugeAndCount(bucket string, value float64, opts ...MetricOption) {
+	if client != nil {
+		client.GaugeAndCount(bucket, value, opts...)
+	}
+}
+
+func GaugeDelta(bucket string, delta float64, opts ...MetricOption) {
+	if client != nil {
+		client.GaugeDelta(bucket, delta, opts...)
+	}
+}
+
+func MinMaxGauge(bucket string, value float64, opts ...MetricOption) {
+	if client != nil {
+		client.MinMaxGauge(bucket, value, opts...)
+	}
+}
+
+func GaugeDeltaString(bucket string, signedValue string, opts ...MetricOption) {
+	if client != nil {
+		client.GaugeDeltaString(bucket, signedValue, opts...)
+	}
+}
+
+func Timing(bucket string, value float64, opts ...MetricOption) {
+	if client != nil {
+		client.Timing(bucket, value, opts...)
+	}
+}
+
+func TimingDuration(bucket string, duration time.Duration, opts ...MetricOption) {
+	if client != nil {
+		client.TimingDuration(bucket, duration, opts...)
+	}
+}
+
+func TimingMsInt(bucket string, ms int64, sampleRate float64, opts ...MetricOption) {
+	if client != nil {
+		client.TimingMsInt(bucket, ms, sampleRate, opts...)
+	}
+}
+
+func TimeInMilliseconds(bucket string, ms float64, sampleRate float64, opts ...MetricOption) {
+	if client != nil {
+		client.TimeInMilliseconds(bucket, ms, sampleRate, opts...)
+	}
+}
+
+func Timer(bucket string, opts ...MetricOption) func() {
+	if client != nil {
+		return client.Timer(bucket, opts...)
+	}
+	return func() {}
+}
+
+func TimerWithTags(bucket string, tags ...string) func() {
+	if client != nil {
+		return client.TimerWithTags(bucket, tags...)
+	}
+	return func() {}
+}
+
+func Instrument(bucket string, f func() error) error {
+	if client != nil {
+		return client.Instrument(bucket, f)
+	}
+	return f()
+}
+
+func CountUnique(bucket string, value string, opts ...MetricOption) {
+	if client != nil {
+		client.CountUnique(bucket, value, opts...)
+	}
+}
+
+func CountUniqueApprox(bucket string, value string, opts ...MetricOption) {
+	if client != nil {
+		client.CountUniqueApprox(bucket, value, opts...)
+	}
+}
+
+func Histogram(bucket string, value float64, sampleRate float64, opts ...MetricOption) {
+	if client != nil {
+		client.Histogram(bucket, value, sampleRate, opts...)
+	}
+}
+
+func HistogramBuckets(bucket string, value float64, bounds []float64, opts ...MetricOption) {
+	if client != nil {
+		client.HistogramBuckets(bucket, value, bounds, opts...)
+	}
+}
+
+func GaugeMulti(value float64, buckets ...string) {
+	if client != nil {
+		client.GaugeMulti(value, buckets...)
+	}
+}
+
+func CountMulti(value float64, buckets ...string) {
+	if client != nil {
+		client.CountMulti(value, buckets...)
+	}
+}
+
+func TimingMulti(value float64, buckets ...string) {
+	if client != nil {
+		client.TimingMulti(value, buckets...)
+	}
+}
+
+func HistogramBatch(bucket string, values []float64, sampleRate float64, tags ...string) {
+	if client != nil {
+		client.HistogramBatch(bucket, values, sampleRate, tags...)
+	}
+}
+
+func Distribution(bucket string, value float64, sampleRate float64, opts ...MetricOption) {
+	if client != nil {
+		client.Distribution(bucket, value, sampleRate, opts...)
+	}
+}
+
+func Observe(m Metric) {
+	if client != nil {
+		client.Observe(m)
+	}
+}
+
+func DefaultTags() []string {
+	if client != nil {
+		return client.DefaultTags()
+	}
+	return nil
+}
+
+func SendRaw(line string) {
+	if client != nil {
+		client.SendRaw(line)
+	}
+}
+
+func WriteBytes(p []byte) error {
+	if client != nil {
+		return client.WriteBytes(p)
+	}
+	return nil
+}
@@ -7,41 +7,572 @@ package statsd
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"math/rand"
+	"io"
+	"log"
+	"math/rand/v2"
 	"net"
+	"os"
+	"os/signal"
 	"regexp"
+	"runtime/debug"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 var (
-	// Regex for sanitizing Unique() values
-	NON_ALPHANUM         = regexp.MustCompile(`[^\w]+`)
+	// ErrResolve is returned, wrapping the underlying error, when the statsd
+	// host name can't be resolved. This usually means the URL is wrong.
+	ErrResolve = errors.New("statsd: can't resolve host")
+
+	// ErrDial is returned, wrapping the underlying error, when the statsd
+	// server can't be dialed for any other reason. This is usually temporary.
+	ErrDial = errors.New("statsd: can't dial host")
+
+	// ErrInvalidPrefix is returned when the prefix contains characters that
+	// are reserved in the statsd line format, like colons or spaces.
+	ErrInvalidPrefix = errors.New("statsd: invalid prefix")
+
+	// ErrDisabled is passed to the error handler, wrapping the error that
+	// kept the client from connecting, when metrics are discarded by the
+	// no-op Client returned in that case.
+	ErrDisabled = errors.New("statsd: metrics are disabled because the client failed to connect")
+)
+
+// WriteError is returned when a packet can't be written to the statsd server
+// in full. It records how much of the packet was written, so that callers can
+// decide whether what's left is worth sending again.
+type WriteError struct {
+	BytesWritten   int
+	BytesRemaining int
+
+	// The underlying error, or io.ErrShortWrite if the connection wrote part
+	// of the packet without returning an error.
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("statsd: wrote %d of %d bytes: %v", e.BytesWritten, e.BytesWritten+e.BytesRemaining, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// checkWrite returns a *WriteError if a write of size bytes failed or was
+// short, or nil if it succeeded.
+func checkWrite(n, size int, err error) error {
+	if err == nil && n < size {
+		err = io.ErrShortWrite
+	}
+	if err == nil {
+		return nil
+	}
+	return &WriteError{BytesWritten: n, BytesRemaining: size - n, Err: err}
+}
+
+var (
+	// Regex for sanitizing Unique() values. Unicode letters, combining marks
+	// and digits are kept.
+	NON_ALPHANUM         = regexp.MustCompile(`[^\p{L}\p{M}\p{N}_]+`)
 	NON_ALPHANUM_REPLACE = []byte{'_'}
 
 	// Statsd metric type flags
-	GAUGE_FLAG       = []byte{'g'}
-	COUNT_FLAG       = []byte{'c'}
-	TIMING_FLAG      = []byte{'m', 's'}
-	CARDINALITY_FLAG = []byte{'s'}
+	GAUGE_FLAG        = []byte{'g'}
+	COUNT_FLAG        = []byte{'c'}
+	TIMING_FLAG       = []byte{'m', 's'}
+	CARDINALITY_FLAG  = []byte{'s'}
+	HISTOGRAM_FLAG    = []byte{'h'}
+	DISTRIBUTION_FLAG = []byte{'d'}
+
+	newline = []byte{'\n'}
+
+	// The value sent by Increment.
+	one = []byte{'1'}
 )
 
 // -- Client
 
 type Client interface {
 	Flush() error
-	Count(bucket string, value float64, sampleRate float64)
-	Gauge(bucket string, value float64)
-	Timing(bucket string, value float64)
-	TimingDuration(bucket string, duration time.Duration)
-	CountUnique(bucket string, value string)
+	FlushIfPending() (bool, error)
+	FlushAndReset() error
+	Drain() []byte
+	Count(bucket string, value float64, sampleRate float64, opts ...MetricOption)
+	Increment(bucket string, opts ...MetricOption)
+	Add(bucket string, value float64, opts ...MetricOption)
+	Gauge(bucket string, value float64, opts ...MetricOption)
+	GaugeMulti(value float64, buckets ...string)
+	CountMulti(value float64, buckets ...string)
+	TimingMulti(value float64, buckets ...string)
+	GaugeBool(bucket string, value bool, opts ...MetricOption)
+	GaugeState(bucket string, state int, opts ...MetricOption)
+	GaugeAndCount(bucket string, value float64, opts ...MetricOption)
+	CountRate(bucket string, value float64, opts ...MetricOption)
+	GaugeDelta(bucket string, delta float64, opts ...MetricOption)
+	MinMaxGauge(bucket string, value float64, opts ...MetricOption)
+	GaugeDeltaString(bucket string, signedValue string, opts ...MetricOption)
+	Timing(bucket string, value float64, opts ...MetricOption)
+	TimingDuration(bucket string, duration time.Duration, opts ...MetricOption)
+	TimingMsInt(bucket string, ms int64, sampleRate float64, opts ...MetricOption)
+	TimeInMilliseconds(bucket string, ms float64, sampleRate float64, opts ...MetricOption)
+	Timer(bucket string, opts ...MetricOption) func()
+	TimerWithTags(bucket string, tags ...string) func()
+	Instrument(bucket string, f func() error) error
+	CountUnique(bucket string, value string, opts ...MetricOption)
+	CountUniqueApprox(bucket string, value string, opts ...MetricOption)
+	Histogram(bucket string, value float64, sampleRate float64, opts ...MetricOption)
+	HistogramBuckets(bucket string, value float64, bounds []float64, opts ...MetricOption)
+	HistogramBatch(bucket string, values []float64, sampleRate float64, tags ...string)
+	Distribution(bucket string, value float64, sampleRate float64, opts ...MetricOption)
+	Observe(m Metric)
+	SendRaw(line string)
+	WriteBytes(p []byte) error
+	Stats() Stats
+	SnapshotStats() Stats
+	Config() Config
+	DefaultTags() []string
+	Prefix() string
+	SetPrefix(prefix string) error
+	RecentLines() []string
+	SetDestination(addr string) error
+	Ping(timeout time.Duration) error
+	FlushOnSignal(sig ...os.Signal)
+	Reconnect() error
+	Close() error
+}
+
+// An Option configures optional behavior of a Client created by New or
+// NewWithPacketSize.
+type Option func(*statsdClient)
+
+// WithUnbuffered causes each stat to be written to the statsd server as soon
+// as it is recorded, in its own packet. It's the same as using a packet size
+// of 0.
+func WithUnbuffered() Option {
+	return func(c *statsdClient) {
+		c.PacketSize = 0
+	}
+}
+
+// WithFlushInterval starts a background goroutine that flushes the buffer
+// every interval, so that stats don't sit in a partially filled buffer
+// indefinitely. The buffer is flushed however far below the packet size it
+// is. Call Close to stop it.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(c *statsdClient) {
+		c.flushInterval = interval
+	}
+}
+
+// WithFlushContext stops the background flusher started by WithFlushInterval
+// when ctx is done, as well as when Close is called, flushing the buffer one
+// last time first. This lets the flusher take part in shutting down with the
+// rest of the program, such as in an errgroup. Without a flush interval, the
+// buffer is flushed once when ctx is done. The client isn't closed, so
+// metrics recorded afterwards are still sent when the buffer fills or Flush
+// is called.
+func WithFlushContext(ctx context.Context) Option {
+	return func(c *statsdClient) {
+		c.flushContext = ctx
+	}
+}
+
+// WithFlushJitter randomizes each background flush interval by up to jitter in
+// either direction. This spreads the flushes of many clients that were started
+// at the same time so they don't all hit the statsd server at once.
+func WithFlushJitter(jitter time.Duration) Option {
+	return func(c *statsdClient) {
+		c.flushJitter = jitter
+	}
+}
+
+// WithErrorHandler sets a function that is called with errors that can't be
+// returned to the caller, such as failed background flushes.
+func WithErrorHandler(handler func(error)) Option {
+	return func(c *statsdClient) {
+		c.errorHandler = handler
+	}
+}
+
+// WithGaugeDedup suppresses gauges whose value is the same as the last value
+// sent for that bucket. The value is sent again once heartbeat has passed since
+// it was last sent so that the statsd server doesn't expire the gauge.
+func WithGaugeDedup(heartbeat time.Duration) Option {
+	return func(c *statsdClient) {
+		c.gaugeHeartbeat = heartbeat
+		c.lastGauges = map[string]lastGauge{}
+	}
+}
+
+// TagOrder determines where tags are placed in a metric line relative to the
+// sample rate.
+type TagOrder int
+
+const (
+	// TagsAfterRate places tags after the sample rate, as DogStatsD expects:
+	// "bucket:1|c|@0.5|#tag:value". This is the default.
+	TagsAfterRate TagOrder = iota
+
+	// TagsBeforeRate places tags before the sample rate:
+	// "bucket:1|c|#tag:value|@0.5".
+	TagsBeforeRate
+)
+
+// NegativeCounts is what a client does with negative counts, for servers that
+// don't accept them.
+type NegativeCounts int
+
+const (
+	// AllowNegativeCounts sends negative counts as they are. This is the
+	// default.
+	AllowNegativeCounts NegativeCounts = iota
+
+	// RejectNegativeCounts drops negative counts, which are counted in
+	// Stats.
+	RejectNegativeCounts
+
+	// DecrementNegativeCounts sends negative counts as positive counts in a
+	// separate "bucket.decrements" counter.
+	DecrementNegativeCounts
+)
+
+// WithNegativeCounts sets what's done with negative values passed to Count,
+// Add and Observe, for servers that drop or clamp negative counters.
+func WithNegativeCounts(policy NegativeCounts) Option {
+	return func(c *statsdClient) {
+		c.negativeCounts = policy
+	}
+}
+
+// WithTags adds tags, in the "key:value" or "value" form, to every metric sent
+// by the client.
+func WithTags(tags ...string) Option {
+	return func(c *statsdClient) {
+		c.tags = append(c.tags, tags...)
+	}
+}
+
+// WithSortedTags sorts the tags of every metric, so that the same tags are
+// always sent in the same order however they were added, which some servers
+// handle more efficiently. Tags are sorted by key and then by value, after
+// duplicate keys are removed. By default tags are sent with the client's
+// default tags first, in the order they were added.
+func WithSortedTags() Option {
+	return func(c *statsdClient) {
+		c.sortTags = true
+	}
+}
+
+// WithBuildTag adds a "version:<version>" tag to every metric, like WithTags,
+// so that metrics can be compared across deploys using the same tag key in
+// every service. The version is usually set at build time, such as with
+// -ldflags "-X main.version=...". If it's empty, the STATSD_VERSION
+// environment variable is used instead, and failing that the VCS revision
+// recorded in the binary by the go command. If none are set, no tag is added.
+func WithBuildTag(version string) Option {
+	if version == "" {
+		version = os.Getenv("STATSD_VERSION")
+	}
+	if version == "" {
+		version = vcsRevision()
+	}
+	return func(c *statsdClient) {
+		if version != "" {
+			c.tags = append(c.tags, "version:"+version)
+		}
+	}
+}
+
+// vcsRevision returns the version control revision the binary was built from,
+// if the go command recorded it.
+func vcsRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// WithTagProvider adds the tags returned by provider to every metric, after any
+// default tags. This is useful for tags that can change while the process is
+// running, like ones from cloud instance metadata. The provider is called at
+// most once per refresh interval and its result is reused in between, so it
+// doesn't need to be fast, but metrics recorded while it runs will wait for
+// it.
+func WithTagProvider(provider func() []string, refresh time.Duration) Option {
+	return func(c *statsdClient) {
+		c.tagProvider = &tagProvider{provide: provider, refresh: refresh}
+	}
+}
+
+// WithTagOrder sets where tags are placed relative to the sample rate.
+func WithTagOrder(order TagOrder) Option {
+	return func(c *statsdClient) {
+		c.tagOrder = order
+	}
+}
+
+// WithNameTransformer sets a function that every bucket name is passed through
+// before it's sent, which can be used to enforce a naming convention. The
+// prefix isn't transformed. For example:
+//
+//	WithNameTransformer(strings.ToLower)
+//	WithNameTransformer(strings.NewReplacer(" ", "_", "/", ".").Replace)
+func WithNameTransformer(transform func(bucket string) string) Option {
+	return func(c *statsdClient) {
+		c.nameTransformer = transform
+	}
+}
+
+// WithFilter drops metrics whose bucket name, after any name transformer, is
+// rejected by allow. Dropped metrics are counted in Stats. This can enforce an
+// approved list of metric names, or catch names that would create too many
+// series.
+func WithFilter(allow func(bucket string) bool) Option {
+	return func(c *statsdClient) {
+		c.filter = allow
+	}
+}
+
+// WithBeforeSend sets a function that's called with every metric that passes
+// sampling and any filter, just before it's formatted and buffered. Metrics
+// it returns false for are dropped and counted in Stats as filtered. It can
+// be used for logging or for custom sampling. The bucket is after any name
+// transformer but without the prefix, kind is the statsd type flag such as
+// "c" or "ms", and tags are the ones given to that call, without the client's
+// default tags. It's called without any lock held, so it may be called
+// concurrently.
+func WithBeforeSend(hook func(bucket, value, kind string, rate float64, tags []string) bool) Option {
+	return func(c *statsdClient) {
+		c.beforeSend = hook
+	}
+}
+
+// WithStartupPing sends a count of 1 to bucket, such as
+// "statsd.client.started", as soon as the client has connected. It shows on
+// dashboards that a new instance's metrics are getting through, even before
+// it records anything else.
+func WithStartupPing(bucket string) Option {
+	return func(c *statsdClient) {
+		c.startupPing = bucket
+	}
+}
+
+// WithNetwork sets the network used to connect to the statsd server, such as
+// "udp" (the default) or "tcp". On stream networks like TCP, each packet is
+// terminated with a newline.
+func WithNetwork(network string) Option {
+	return func(c *statsdClient) {
+		c.network = network
+	}
+}
+
+// WithTLS connects to the statsd server over TCP using TLS with the given
+// configuration.
+func WithTLS(config *tls.Config) Option {
+	return func(c *statsdClient) {
+		c.network = "tcp"
+		c.tlsConfig = config
+	}
+}
+
+// WithPrefixSeparator sets the separator that is appended to the prefix from
+// the URL path if it doesn't already end with it. The default is a period, so
+// "statsd://host:8125/app" sends "app.bucket". With a separator of "_" it
+// sends "app_bucket" instead.
+func WithPrefixSeparator(separator string) Option {
+	return func(c *statsdClient) {
+		c.prefixSeparator = separator
+	}
+}
+
+// WithTrimPrefixDot cleans up the prefix before it's used, trimming the
+// separator, a period by default, from both ends and collapsing repeated ones,
+// so that a misconfigured prefix like ".app..web." becomes "app.web.". It
+// applies to prefixes from the URL, the constructors, SetPrefix and
+// WithPrefixFunc.
+func WithTrimPrefixDot() Option {
+	return func(c *statsdClient) {
+		c.trimPrefix = true
+	}
+}
+
+// WithPrefixFunc calls prefix for every metric to get the prefix of its name,
+// instead of using the prefix given to the constructor. This allows the prefix
+// to change at any time, such as to include the current tenant. The separator
+// is appended as usual. The result for the last prefix returned is cached, so
+// the function should be cheap but doesn't need to cache anything itself.
+//
+// A prefix containing reserved characters is reported to the error handler
+// and the constructor's prefix is used instead.
+func WithPrefixFunc(prefix func() string) Option {
+	return func(c *statsdClient) {
+		c.prefixFunc = &prefixFunc{get: prefix}
+	}
+}
+
+// WithTypePrefixes adds an extra prefix to the names of metrics of the given
+// types, after the client's prefix. For example, with a prefix of "app." and
+// a TimingMetric prefix of "timers.", a timing for "db.query" is sent as
+// "app.timers.db.query". The type prefixes are used as given, so they should
+// include a trailing separator.
+func WithTypePrefixes(prefixes map[MetricType]string) Option {
+	return func(c *statsdClient) {
+		c.typePrefixes = map[string][]byte{}
+		for kind, prefix := range prefixes {
+			c.typePrefixes[string(kind.flag())] = []byte(prefix)
+		}
+	}
+}
+
+// WithChangesSuffix sets the suffix of the counter incremented by
+// GaugeAndCount. The default is ".changes".
+func WithChangesSuffix(suffix string) Option {
+	return func(c *statsdClient) {
+		c.changesSuffix = suffix
+	}
+}
+
+// WithLineSeparator sets the byte that separates metric lines within a packet,
+// for servers that expect something other than a newline. Metrics whose
+// name, value or tags contain the separator are dropped, since they would be
+// split into corrupt lines, and counted in Stats.
+func WithLineSeparator(separator byte) Option {
+	return func(c *statsdClient) {
+		c.buffer.separator = []byte{separator}
+	}
+}
+
+// WithTrailingSeparator ends every packet with the line separator, for
+// collectors that expect every line to be terminated. The separator counts
+// towards the packet size. Packets sent over stream networks always end with
+// a newline instead.
+func WithTrailingSeparator() Option {
+	return func(c *statsdClient) {
+		c.trailingSeparator = true
+	}
+}
+
+// WithSampleRatePrecision rounds the sample rate sent with sampled metrics to
+// at most digits decimal places, dropping trailing zeros, so that a rate like
+// 1/3 is sent as "@0.333" with a precision of 3. Rates that would round to 0
+// are sent in full. By default the rate is sent with as many digits as it
+// needs. Rates are never sent with an exponent.
+func WithSampleRatePrecision(digits int) Option {
+	return func(c *statsdClient) {
+		c.ratePrecision = digits
+	}
+}
+
+// DefaultSampleRate can be passed as the sample rate of a metric to use the
+// client's default sample rate, as set by WithDefaultSampleRate.
+const DefaultSampleRate = -1
+
+// WithDefaultSampleRate sets the sample rate of counters and timings recorded
+// without one, by methods like Add, Increment and Timing, or with a rate of
+// DefaultSampleRate. This can turn down the volume of all metrics at once.
+// Explicit sample rates, including 1, aren't changed. Rates outside of (0, 1]
+// are ignored. The default is 1.
+func WithDefaultSampleRate(rate float64) Option {
+	return func(c *statsdClient) {
+		if rate > 0 && rate <= 1 {
+			c.defaultSampleRate = rate
+		}
+	}
+}
+
+// WithMaxLineLength limits the length of each metric line, for servers that
+// drop longer lines. A line that's too long is sent without its tags, or
+// dropped if it's still too long without them. Both are counted in Stats. By
+// default the length is unlimited.
+func WithMaxLineLength(length int) Option {
+	return func(c *statsdClient) {
+		c.maxLineLength = length
+	}
+}
+
+// WithTimestamps sends the timestamp of metrics recorded with one, such as
+// those passed to Observe with a Timestamp, in DogStatsD's "|T<unix time>"
+// form. Plain statsd servers don't support timestamps, so they aren't sent
+// unless this option is used.
+func WithTimestamps() Option {
+	return func(c *statsdClient) {
+		c.timestamps = true
+	}
+}
+
+// WithFlushTimestamps sends every line with the time it was flushed, in the
+// same form as WithTimestamps, for backends like Graphite and InfluxDB that
+// store a timestamp with each point. The clock is read once per flush, so all
+// the lines in a flush, including client-side aggregates, share a timestamp.
+// Lines recorded with their own timestamp keep it. Room for the timestamps is
+// left in each packet.
+func WithFlushTimestamps() Option {
+	return func(c *statsdClient) {
+		c.timestamps = true
+		c.flushTimestamps = true
+	}
+}
+
+// WithVectoredWrites keeps buffered metric lines as separate slices and sends
+// them with a single vectored write (writev) when flushing, instead of copying
+// each line into one contiguous buffer. This saves a copy per metric at very
+// high rates. Connections that don't support vectored writes, like TLS ones,
+// fall back to a contiguous buffer when flushing.
+func WithVectoredWrites() Option {
+	return func(c *statsdClient) {
+		c.buffer.vectored = true
+	}
+}
+
+// WithConnectionlessUDP uses an unconnected UDP socket that sends each packet
+// to a destination address, which can be changed at any time with
+// SetDestination without replacing the socket.
+func WithConnectionlessUDP() Option {
+	return func(c *statsdClient) {
+		c.connectionless = true
+	}
+}
+
+// WithDialRetries makes the constructor retry connecting to the server up to
+// retries more times, waiting delay between attempts, before it gives up and
+// returns a no-op Client. This covers services that start just before their
+// statsd agent does. Once connected, Reconnect only tries once.
+func WithDialRetries(retries int, delay time.Duration) Option {
+	return func(c *statsdClient) {
+		c.dialRetries = retries
+		c.dialRetryDelay = delay
+	}
+}
+
+// WithWriteBufferSize sets the size of the socket's send buffer, in bytes, so
+// that it can absorb bursts of packets when flushing. By default the operating
+// system's default is used, which on Linux is net.core.wmem_default, usually
+// around 200KB, and can't be raised past net.core.wmem_max. A few megabytes is
+// enough for most bursts. It has no effect on TLS connections.
+func WithWriteBufferSize(size int) Option {
+	return func(c *statsdClient) {
+		c.writeBufferSize = size
+	}
 }
 
 // New is the same as calling NewWithPacketSize with a 512 byte packet size.
-func New(statsdUrl string) (Client, error) {
-	return NewWithPacketSize(statsdUrl, 512)
+func New(statsdUrl string, options ...Option) (Client, error) {
+	return NewWithPacketSize(statsdUrl, 512, options...)
 }
 
 // NewWithPacketSize creates a new Client that will direct stats to a Statsd
@@ -52,144 +583,2381 @@ func New(statsdUrl string) (Client, error) {
 // buffered before being sent. A value of 0 or less will cause each stat to be
 // sent immediately, as it is received.
 //
+// Options are applied in order before connecting to the server.
+//
+// The URL path may not contain characters that are reserved in the statsd
+// line format, such as colons or spaces; if it does, an error wrapping
+// ErrInvalidPrefix is returned.
+//
 // If there is an error resolving the host, NewWithPacketSize will return an
 // error as well as a no-op StatsReporter so that code mixed with statsd calls
-// can continue to run without errors.
-func NewWithPacketSize(statsdUrl string, packetSize int) (Client, error) {
-	// Seed random number generator for dealing with sample rates.
-	rand.Seed(time.Now().UnixNano())
+// can continue to run without errors. Dial errors wrap either ErrResolve or
+// ErrDial so that callers can tell bad configuration from a temporary outage
+// using errors.Is.
+func NewWithPacketSize(statsdUrl string, packetSize int, options ...Option) (Client, error) {
+	c := newStatsdClient(packetSize, options)
+	empty := newEmptyClient(c, func() (Client, error) {
+		return NewWithPacketSize(statsdUrl, packetSize, reconnectOptions(options)...)
+	})
+
+	host, prefix, err := parseUrlWithSeparator(statsdUrl, c.prefixSeparator)
+	if err == nil {
+		prefix, err = c.validPrefix(prefix)
+	}
+	if err != nil {
+		return empty.failed(err)
+	}
+	c.host = host
+	c.prefix = []byte(prefix)
 
-	host, prefix, err := parseUrl(statsdUrl)
-	connection, err := net.DialTimeout("udp", host, time.Second)
+	c.conn, err = c.dialWithRetries()
 	if err != nil {
-		return &emptyClient{}, err
+		return empty.failed(err)
 	}
+	c.start()
 
-	return &statsdClient{
-		PacketSize: packetSize,
-		conn:       connection,
-		prefix:     []byte(prefix),
-		buffer:     lockableBuffer{},
-	}, nil
+	return c, nil
 }
 
-// -- emptyClient
+// NewSRV creates a new Client that sends stats to the target of the given DNS
+// SRV record, such as "_statsd._udp.example.com", with a 512 byte packet size.
+// All metric names will be prepended with prefix, followed by a period if it
+// doesn't already end with one.
+//
+// The SRV record is looked up again every time the client reconnects, so
+// calling Reconnect picks up changes to the record. If the lookup fails, NewSRV
+// returns an error wrapping ErrResolve as well as a no-op Client.
+func NewSRV(service string, prefix string, options ...Option) (Client, error) {
+	c := newStatsdClient(512, options)
+	empty := newEmptyClient(c, func() (Client, error) {
+		return NewSRV(service, prefix, reconnectOptions(options)...)
+	})
+	c.resolve = func() (string, error) {
+		return lookupSRV(service)
+	}
 
-type emptyClient struct{}
+	validPrefix, err := c.validPrefix(prefix)
+	if err != nil {
+		return empty.failed(err)
+	}
+	c.prefix = []byte(validPrefix)
 
-func (c emptyClient) Flush() error                         { return nil }
-func (c emptyClient) Count(string, float64, float64)       {}
-func (c emptyClient) Gauge(string, float64)                {}
-func (c emptyClient) Timing(string, float64)               {}
-func (c emptyClient) TimingDuration(string, time.Duration) {}
-func (c emptyClient) CountUnique(string, string)           {}
+	c.conn, err = c.dialWithRetries()
+	if err != nil {
+		return empty.failed(err)
+	}
+	c.start()
 
-// -- statsdClient
+	return c, nil
+}
 
-type lockableBuffer struct {
-	bytes.Buffer
-	sync.Mutex
+// newStatsdClient returns an unconnected client with the given options
+// applied.
+func newStatsdClient(packetSize int, options []Option) *statsdClient {
+	c := &statsdClient{
+		PacketSize:        packetSize,
+		network:           "udp",
+		prefixSeparator:   ".",
+		changesSuffix:     ".changes",
+		defaultSampleRate: 1,
+		rateSuffix:        ".per_second",
+		buffer:            lockableBuffer{},
+		clock:             realClock{},
+		aggregator:        &aggregator{},
+		closed:            make(chan struct{}),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	c.aggregator.lastEmit = c.clock.Now()
+	c.incrementSuffix = c.plainIncrementSuffix()
+	return c
 }
 
-type statsdClient struct {
-	// Maximum size of sent UDP packets, in bytes. A value of 0 or less will
-	// cause all stats to be sent immediately.
-	PacketSize int
+// plainIncrementSuffix returns what follows the bucket in a line sent by
+// Increment without options, or nil if an option could change the line or
+// where it goes, such as by sampling, renaming or filtering it.
+func (c *statsdClient) plainIncrementSuffix() []byte {
+	if c.defaultSampleRate != 1 || c.nameStyle != NamesAsIs || c.nameTransformer != nil ||
+		c.suspicious != nil || c.filter != nil || c.beforeSend != nil || c.tagProvider != nil ||
+		c.queue != nil || c.recent != nil || c.aggregator.cumulativeCounts || c.buffer.vectored {
+		return nil
+	}
+	suffix := append([]byte{':'}, one...)
+	suffix = append(append(suffix, '|'), COUNT_FLAG...)
+	return c.appendTags(suffix, nil)
+}
 
-	// Prefix for all metric names. If non-blank, this should include the
-	// trailing period.
-	prefix []byte
+// lookupSRV returns the "host:port" address of the first target of an SRV
+// record.
+func lookupSRV(service string) (string, error) {
+	_, addrs, err := net.LookupSRV("", "", service)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no SRV records for %#v", service)
+	}
 
-	// UDP connection to Statsd
-	conn net.Conn
+	target := strings.TrimSuffix(addrs[0].Target, ".")
+	return net.JoinHostPort(target, strconv.Itoa(int(addrs[0].Port))), nil
+}
 
-	// Buffer metrics before sending to Statsd as UDP packets.
-	buffer lockableBuffer
+// start starts any background work needed by the client's options.
+func (c *statsdClient) start() {
+	if c.queue != nil {
+		c.queueDone = make(chan struct{})
+		go c.queueLoop()
+	}
+	if c.flushInterval > 0 {
+		c.done = make(chan struct{})
+		c.flushRequests = make(chan chan error)
+		go c.flushLoop()
+	} else if c.flushContext != nil {
+		go c.flushOnDone()
+	}
+	if c.idleFlush > 0 {
+		c.activity = make(chan struct{}, 1)
+		go c.idleFlushLoop()
+	}
+	if c.startupPing != "" {
+		c.Count(c.startupPing, 1, 1, FlushImmediately())
+	}
 }
 
-func (c *statsdClient) record(sampleRate float64, bucket, value, kind []byte) {
-	if sampleRate < 1 && sampleRate <= rand.Float64() {
-		return
+// reconnectOptions returns the options for the client created by Reconnect,
+// which only tries to connect once, even if the original client was created
+// WithDialRetries.
+func reconnectOptions(options []Option) []Option {
+	return append(options[:len(options):len(options)], WithDialRetries(0, 0))
+}
+
+// dialWithRetries is dial, retried as configured by WithDialRetries.
+func (c *statsdClient) dialWithRetries() (net.Conn, error) {
+	connection, err := c.dial()
+	for retry := 0; err != nil && retry < c.dialRetries; retry++ {
+		<-c.clock.NewTimer(c.dialRetryDelay).C()
+		connection, err = c.dial()
 	}
+	return connection, err
+}
 
-	sampleRateBytes := []byte{}
-	if sampleRate != 1 {
-		sampleRateBytes = []byte(fmt.Sprintf("|@%g", sampleRate))
+func (c *statsdClient) dial() (net.Conn, error) {
+	connection, err := c.dialHost()
+	if err != nil || c.writeBufferSize <= 0 {
+		return connection, err
 	}
 
-	if c.PacketSize <= 0 {
-		c.writeMetric(bucket, value, kind, sampleRateBytes)
-		c.Flush()
+	if err := setWriteBuffer(connection, c.writeBufferSize); err != nil {
+		connection.Close()
+		return nil, fmt.Errorf("%w: setting write buffer size: %w", ErrDial, err)
+	}
+	return connection, nil
+}
+
+// setWriteBuffer sets the size of a connection's socket send buffer, if it has
+// one that can be changed.
+func setWriteBuffer(connection net.Conn, size int) error {
+	var conn any = connection
+	if packet, ok := connection.(*packetConn); ok {
+		conn = packet.PacketConn
+	}
+	if setter, ok := conn.(interface{ SetWriteBuffer(int) error }); ok {
+		return setter.SetWriteBuffer(size)
+	}
+	return nil
+}
+
+// dialHost connects to the statsd server without configuring the connection.
+func (c *statsdClient) dialHost() (connection net.Conn, err error) {
+	if c.debugLogger != nil {
+		return &debugConn{logger: c.debugLogger}, nil
+	}
+	if c.borrowedConn != nil {
+		return c.borrowedConn, nil
+	}
+
+	host := c.host
+	if c.resolve != nil {
+		host, err = c.resolve()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrResolve, err)
+		}
+	}
+
+	if c.connectionless {
+		return listenPacket(c.network, host)
+	}
+
+	if c.tlsConfig != nil {
+		dialer := &net.Dialer{Timeout: time.Second}
+		connection, err = tls.DialWithDialer(dialer, c.network, host, c.tlsConfig)
 	} else {
-		// FIXME: This is a little nasty.
-		if c.buffer.Len()+1+len(c.prefix)+len(bucket)+1+len(value)+1+len(kind)+len(sampleRateBytes) > c.PacketSize {
-			c.Flush()
+		connection, err = net.DialTimeout(c.network, host, time.Second)
+	}
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return nil, fmt.Errorf("%w: %w", ErrResolve, err)
 		}
-		c.writeMetric(bucket, value, kind, sampleRateBytes)
+		return nil, fmt.Errorf("%w: %w", ErrDial, err)
 	}
+	return connection, nil
 }
 
-func (c *statsdClient) writeMetric(bucket, value, kind, sampleRate []byte) {
-	c.buffer.Lock()
-	defer c.buffer.Unlock()
+// -- emptyClient
+
+// emptyClient discards all stats. It's returned when the statsd host can't be
+// reached so that code mixed with statsd calls can keep running. Once
+// Reconnect succeeds, stats are passed through to a real client.
+type emptyClient struct {
+	// Creates the real client, with the same arguments as the original
+	// constructor call.
+	connect func() (Client, error)
+
+	mu     sync.RWMutex
+	client Client
+
+	// The error handler of the real client, if any, is told when metrics are
+	// discarded because of err, at most once per disabledReportInterval.
+	errorHandler func(error)
+	clock        clock
+	err          error
+	lastReport   time.Time
+}
+
+// disabledReportInterval is how often an emptyClient reports that it's
+// discarding metrics.
+const disabledReportInterval = time.Minute
+
+// newEmptyClient returns an emptyClient that reports discarded metrics to c's
+// error handler.
+func newEmptyClient(c *statsdClient, connect func() (Client, error)) *emptyClient {
+	return &emptyClient{connect: connect, errorHandler: c.errorHandler, clock: c.clock}
+}
 
-	if c.buffer.Len() > 0 {
-		c.buffer.WriteRune('\n')
+// failed records the error that kept the real client from being created, and
+// returns the emptyClient along with it.
+func (c *emptyClient) failed(err error) (Client, error) {
+	c.err = err
+	return c, err
+}
+
+// Reconnect tries to create a real client for the original statsd server. If
+// it succeeds, all further stats are sent through that client. If another call
+// created one first, the new one is closed.
+func (c *emptyClient) Reconnect() error {
+	if client := c.connected(); client != nil {
+		return client.Reconnect()
+	}
+
+	client, err := c.connect()
+	if err != nil {
+		return err
 	}
 
-	c.buffer.Write(c.prefix)
-	c.buffer.Write(bucket)
-	c.buffer.WriteRune(':')
-	c.buffer.Write(value)
-	c.buffer.WriteRune('|')
-	c.buffer.Write(kind)
-	c.buffer.Write(sampleRate)
+	c.mu.Lock()
+	if c.client != nil {
+		c.mu.Unlock()
+		return client.Close()
+	}
+	c.client = client
+	c.mu.Unlock()
+	return nil
 }
 
-// Flush sends all buffered data to the statsd server, if there is any in the
-// buffer, and empties the buffer.
-func (c *statsdClient) Flush() (err error) {
-	c.buffer.Lock()
-	defer c.buffer.Unlock()
+func (c *emptyClient) connected() Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.client
+}
 
-	if c.buffer.Len() > 0 {
-		_, err = c.buffer.WriteTo(c.conn)
-		c.buffer.Reset()
+// metricClient returns the real client to record a metric through, if there
+// is one. Otherwise the metric is discarded, which is reported to the error
+// handler.
+func (c *emptyClient) metricClient() Client {
+	if client := c.connected(); client != nil {
+		return client
 	}
-	return err
+	if c.errorHandler == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	now := c.clock.Now()
+	report := c.lastReport.IsZero() || now.Sub(c.lastReport) >= disabledReportInterval
+	if report {
+		c.lastReport = now
+	}
+	c.mu.Unlock()
+
+	if report {
+		c.errorHandler(fmt.Errorf("%w: %w", ErrDisabled, c.err))
+	}
+	return nil
 }
 
-// Gauge sets an arbitrary value. Only the value of the gauge at flush time is
-// stored by statsd.
-func (c *statsdClient) Gauge(bucket string, value float64) {
-	valueString := strconv.FormatFloat(value, 'f', -1, 64)
-	c.record(1, []byte(bucket), []byte(valueString), GAUGE_FLAG)
+func (c *emptyClient) Close() error {
+	if client := c.connected(); client != nil {
+		return client.Close()
+	}
+	return nil
 }
 
-// Count increments (or decrements) the value in a counter. Counters are
-// recorded and then reset to 0 when Statsd flushes.
-func (c *statsdClient) Count(bucket string, value float64, sampleRate float64) {
-	valueString := strconv.FormatFloat(value, 'f', -1, 64)
-	c.record(sampleRate, []byte(bucket), []byte(valueString), COUNT_FLAG)
+func (c *emptyClient) Flush() error {
+	if client := c.connected(); client != nil {
+		return client.Flush()
+	}
+	return nil
 }
 
-// Timing records a time interval (in milliseconds). The percentiles, mean,
-// standard deviation, sum, and lower and upper bounds are calculated by the
-// Statsd server.
-func (c *statsdClient) Timing(bucket string, value float64) {
-	valueString := strconv.FormatFloat(value, 'f', -1, 64)
-	c.record(1, []byte(bucket), []byte(valueString), TIMING_FLAG)
+func (c *emptyClient) FlushIfPending() (bool, error) {
+	if client := c.connected(); client != nil {
+		return client.FlushIfPending()
+	}
+	return false, nil
 }
 
-// TimingDuration is the same as Timing except that it takes a time.Duration
-// value.
-func (c *statsdClient) TimingDuration(bucket string, duration time.Duration) {
-	c.Timing(bucket, float64(duration)/float64(time.Millisecond))
+func (c *emptyClient) FlushAndReset() error {
+	if client := c.connected(); client != nil {
+		return client.FlushAndReset()
+	}
+	return nil
 }
 
-// Unique records the number of unique values received between flushes using
-// Statsd Sets.
-func (c *statsdClient) CountUnique(bucket string, value string) {
-	cleanValue := NON_ALPHANUM.ReplaceAll([]byte(value), NON_ALPHANUM_REPLACE)
-	c.record(1, []byte(bucket), cleanValue, CARDINALITY_FLAG)
+func (c *emptyClient) Drain() []byte {
+	if client := c.connected(); client != nil {
+		return client.Drain()
+	}
+	return nil
+}
+
+func (c *emptyClient) Count(bucket string, value float64, sampleRate float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.Count(bucket, value, sampleRate, opts...)
+	}
+}
+
+func (c *emptyClient) Increment(bucket string, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.Increment(bucket, opts...)
+	}
+}
+
+func (c *emptyClient) CountRate(bucket string, value float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.CountRate(bucket, value, opts...)
+	}
+}
+
+func (c *emptyClient) Add(bucket string, value float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.Add(bucket, value, opts...)
+	}
+}
+
+func (c *emptyClient) Gauge(bucket string, value float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.Gauge(bucket, value, opts...)
+	}
+}
+
+func (c *emptyClient) GaugeBool(bucket string, value bool, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.GaugeBool(bucket, value, opts...)
+	}
+}
+
+func (c *emptyClient) GaugeState(bucket string, state int, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.GaugeState(bucket, state, opts...)
+	}
+}
+
+func (c *emptyClient) GaugeAndCount(bucket string, value float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.GaugeAndCount(bucket, value, opts...)
+	}
+}
+
+func (c *emptyClient) GaugeDelta(bucket string, delta float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.GaugeDelta(bucket, delta, opts...)
+	}
+}
+
+func (c *emptyClient) MinMaxGauge(bucket string, value float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.MinMaxGauge(bucket, value, opts...)
+	}
+}
+
+func (c *emptyClient) GaugeDeltaString(bucket string, signedValue string, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.GaugeDeltaString(bucket, signedValue, opts...)
+	}
+}
+
+func (c *emptyClient) Timing(bucket string, value float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.Timing(bucket, value, opts...)
+	}
+}
+
+func (c *emptyClient) TimingDuration(bucket string, duration time.Duration, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.TimingDuration(bucket, duration, opts...)
+	}
+}
+
+func (c *emptyClient) TimingMsInt(bucket string, ms int64, sampleRate float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.TimingMsInt(bucket, ms, sampleRate, opts...)
+	}
+}
+
+func (c *emptyClient) TimeInMilliseconds(bucket string, ms float64, sampleRate float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.TimeInMilliseconds(bucket, ms, sampleRate, opts...)
+	}
+}
+
+func (c *emptyClient) Timer(bucket string, opts ...MetricOption) func() {
+	if client := c.metricClient(); client != nil {
+		return client.Timer(bucket, opts...)
+	}
+	return func() {}
+}
+
+func (c *emptyClient) TimerWithTags(bucket string, tags ...string) func() {
+	if client := c.metricClient(); client != nil {
+		return client.TimerWithTags(bucket, tags...)
+	}
+	return func() {}
+}
+
+func (c *emptyClient) Instrument(bucket string, f func() error) error {
+	if client := c.metricClient(); client != nil {
+		return client.Instrument(bucket, f)
+	}
+	return f()
+}
+
+func (c *emptyClient) CountUnique(bucket string, value string, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.CountUnique(bucket, value, opts...)
+	}
+}
+
+func (c *emptyClient) CountUniqueApprox(bucket string, value string, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.CountUniqueApprox(bucket, value, opts...)
+	}
+}
+
+func (c *emptyClient) Histogram(bucket string, value float64, sampleRate float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.Histogram(bucket, value, sampleRate, opts...)
+	}
+}
+
+func (c *emptyClient) HistogramBuckets(bucket string, value float64, bounds []float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.HistogramBuckets(bucket, value, bounds, opts...)
+	}
+}
+
+func (c *emptyClient) GaugeMulti(value float64, buckets ...string) {
+	if client := c.metricClient(); client != nil {
+		client.GaugeMulti(value, buckets...)
+	}
+}
+
+func (c *emptyClient) CountMulti(value float64, buckets ...string) {
+	if client := c.metricClient(); client != nil {
+		client.CountMulti(value, buckets...)
+	}
+}
+
+func (c *emptyClient) TimingMulti(value float64, buckets ...string) {
+	if client := c.metricClient(); client != nil {
+		client.TimingMulti(value, buckets...)
+	}
+}
+
+func (c *emptyClient) HistogramBatch(bucket string, values []float64, sampleRate float64, tags ...string) {
+	if client := c.metricClient(); client != nil {
+		client.HistogramBatch(bucket, values, sampleRate, tags...)
+	}
+}
+
+func (c *emptyClient) Distribution(bucket string, value float64, sampleRate float64, opts ...MetricOption) {
+	if client := c.metricClient(); client != nil {
+		client.Distribution(bucket, value, sampleRate, opts...)
+	}
+}
+
+func (c *emptyClient) Observe(m Metric) {
+	if client := c.metricClient(); client != nil {
+		client.Observe(m)
+	}
+}
+
+func (c *emptyClient) SendRaw(line string) {
+	if client := c.metricClient(); client != nil {
+		client.SendRaw(line)
+	}
+}
+
+func (c *emptyClient) WriteBytes(p []byte) error {
+	if client := c.metricClient(); client != nil {
+		return client.WriteBytes(p)
+	}
+	return errors.New("statsd: not connected")
+}
+
+func (c *emptyClient) SetDestination(addr string) error {
+	if client := c.connected(); client != nil {
+		return client.SetDestination(addr)
+	}
+	return errors.New("statsd: not connected")
+}
+
+func (c *emptyClient) Ping(timeout time.Duration) error {
+	if client := c.connected(); client != nil {
+		return client.Ping(timeout)
+	}
+	return errors.New("statsd: not connected")
+}
+
+func (c *emptyClient) FlushOnSignal(sig ...os.Signal) {
+	flushOnSignal(c, sig)
+}
+
+func (c *emptyClient) Config() Config {
+	if client := c.connected(); client != nil {
+		return client.Config()
+	}
+	return Config{}
+}
+
+func (c *emptyClient) DefaultTags() []string {
+	if client := c.connected(); client != nil {
+		return client.DefaultTags()
+	}
+	return nil
+}
+
+func (c *emptyClient) Prefix() string {
+	if client := c.connected(); client != nil {
+		return client.Prefix()
+	}
+	return ""
+}
+
+func (c *emptyClient) SetPrefix(prefix string) error {
+	if client := c.connected(); client != nil {
+		return client.SetPrefix(prefix)
+	}
+	return errors.New("statsd: not connected")
+}
+
+func (c *emptyClient) RecentLines() []string {
+	if client := c.connected(); client != nil {
+		return client.RecentLines()
+	}
+	return nil
+}
+
+func (c *emptyClient) Stats() Stats {
+	if client := c.connected(); client != nil {
+		return client.Stats()
+	}
+	return Stats{}
+}
+
+func (c *emptyClient) SnapshotStats() Stats {
+	if client := c.connected(); client != nil {
+		return client.SnapshotStats()
+	}
+	return Stats{}
+}
+
+// -- statsdClient
+
+type lockableBuffer struct {
+	bytes.Buffer
+	sync.Mutex
+
+	// Added between lines, if set. Otherwise lines are separated by
+	// newlines.
+	separator []byte
+
+	// When vectored, lines are kept as a list of slices instead of being
+	// copied into Buffer, and written with a single vectored write.
+	vectored bool
+	lines    net.Buffers
+	linesLen int
+
+	// Number of metric lines buffered.
+	lineCount int
+}
+
+// size returns the number of bytes buffered.
+func (b *lockableBuffer) size() int {
+	if b.vectored {
+		return b.linesLen
+	}
+	return b.Len()
+}
+
+// add appends p to the buffer. When vectored, p is kept rather than copied, so
+// it must not be modified afterwards.
+func (b *lockableBuffer) add(p []byte) {
+	if b.vectored {
+		b.lines = append(b.lines, p)
+		b.linesLen += len(p)
+	} else {
+		b.Write(p)
+	}
+}
+
+// lineSeparator returns the bytes that separate lines.
+func (b *lockableBuffer) lineSeparator() []byte {
+	if b.separator == nil {
+		return newline
+	}
+	return b.separator
+}
+
+// addLine appends a line, preceded by the separator if the buffer isn't empty.
+func (b *lockableBuffer) addLine(line []byte) {
+	if b.size() > 0 {
+		b.add(b.lineSeparator())
+	}
+	b.add(line)
+	b.lineCount += 1 + bytes.Count(line, b.lineSeparator())
+}
+
+// packet returns the buffered bytes. When vectored, they're joined into a new
+// slice.
+func (b *lockableBuffer) packet() []byte {
+	if b.vectored {
+		return bytes.Join(b.lines, nil)
+	}
+	return b.Bytes()
+}
+
+func (b *lockableBuffer) reset() {
+	b.Reset()
+	b.lines = b.lines[:0]
+	b.linesLen = 0
+	b.lineCount = 0
+}
+
+type statsdClient struct {
+	// Maximum size of sent UDP packets, in bytes. A value of 0 or less will
+	// cause all stats to be sent immediately.
+	PacketSize int
+
+	// Prefix for all metric names. If non-blank, this should include the
+	// trailing separator, which is a period by default. It's guarded by
+	// prefixLock once the client is created.
+	prefix          []byte
+	prefixLock      sync.RWMutex
+	prefixSeparator string
+
+	// Whether to trim separators from prefixes before using them.
+	trimPrefix bool
+
+	// If set, called to get the prefix of each metric instead of prefix.
+	prefixFunc *prefixFunc
+
+	// Extra prefixes for particular metric types, keyed by type flag.
+	typePrefixes map[string][]byte
+
+	// Tags added to every metric, where they are placed relative to the
+	// sample rate, and whether each metric's tags are sorted.
+	tags        []string
+	tagProvider *tagProvider
+	tagOrder    TagOrder
+	sortTags    bool
+
+	// Whether metric timestamps are sent.
+	timestamps bool
+
+	// Sample rate used in place of DefaultSampleRate.
+	defaultSampleRate float64
+
+	// What follows the bucket in a line sent by Increment without options,
+	// including the default tags, if no option changes such a line. Nil if
+	// Increment has to take the general path.
+	incrementSuffix []byte
+
+	// What's done with negative counts.
+	negativeCounts NegativeCounts
+
+	// Whether lines are sent with the time they were flushed, which is set
+	// for the duration of a call to Flush. Guarded by the buffer lock.
+	flushTimestamps bool
+	flushTime       time.Time
+
+	// Whether to end every packet with the line separator.
+	trailingSeparator bool
+
+	// Maximum length of a metric line, if greater than 0.
+	maxLineLength int
+
+	// Decimal places in sent sample rates, if greater than 0.
+	ratePrecision int
+
+	// Appended to the bucket of the counter incremented by GaugeAndCount.
+	changesSuffix string
+
+	// Appended to the bucket of the per-second gauge sent for CountRate, and
+	// the interval the count is divided by, if set.
+	rateSuffix   string
+	rateInterval time.Duration
+
+	// Applied to every bucket name, if set.
+	nameStyle       NameStyle
+	nameTransformer func(string) string
+
+	// If set, metrics whose bucket name it rejects are dropped.
+	filter func(string) bool
+
+	// If set, checks every bucket name, and reports the suspicious ones
+	// instead of counting them.
+	suspicious       func(string) bool
+	reportSuspicious func(string)
+
+	// If set, packets that can't be sent are kept in it.
+	spill *spillFile
+
+	// If set, called with every metric before it's buffered.
+	beforeSend func(bucket, value, kind string, rate float64, tags []string) bool
+
+	// Bucket counted once the client has connected, if set.
+	startupPing string
+
+	// Network and address of the Statsd server, used when reconnecting.
+	network   string
+	host      string
+	tlsConfig *tls.Config
+
+	// Whether conn is an unconnected packetConn.
+	connectionless bool
+
+	// Connection provided to NewWithPacketConn, which is used instead of
+	// dialing.
+	borrowedConn *packetConn
+
+	// Size of the socket's send buffer, if greater than 0.
+	writeBufferSize int
+
+	// Number of times the constructor retries dialing, and the delay in
+	// between.
+	dialRetries    int
+	dialRetryDelay time.Duration
+
+	// If set, metrics are logged here instead of being sent to a server.
+	debugLogger *log.Logger
+
+	// If set, called to find the address of the Statsd server each time the
+	// client dials instead of using host.
+	resolve func() (string, error)
+
+	// Connection to Statsd, usually over UDP.
+	conn net.Conn
+
+	// Buffer metrics before sending to Statsd as UDP packets.
+	buffer lockableBuffer
+
+	// Full packets waiting to be written together, and how many to collect
+	// before writing them, when batched writes are enabled. Guarded by the
+	// buffer's lock.
+	batch     [][]byte
+	batchSize int
+
+	// The Flush in progress, if any. Never locked before buffer.
+	flushing  *flushCall
+	flushLock sync.Mutex
+
+	// Interval between background flushes, and the maximum random amount
+	// added to or removed from it. No background flushes are done if the
+	// interval is 0 or less.
+	flushInterval time.Duration
+	flushJitter   time.Duration
+
+	// Stops the background flusher when done, if set.
+	flushContext context.Context
+
+	// Quiet period after which the buffer is flushed, if greater than 0.
+	// Recording a metric signals activity and stores the time in
+	// lastActivity, as Unix nanoseconds.
+	idleFlush    time.Duration
+	activity     chan struct{}
+	lastActivity atomic.Int64
+
+	// Errors found with the buffer locked, reported once it's unlocked.
+	lockedErrors []error
+
+	// Number of times a non-empty buffer has been flushed.
+	flushes atomic.Uint64
+
+	// Hashes set values before they're sent, if set.
+	hashSetValues func(value string) string
+
+	// Closed to stop the background flusher.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Receives flushes for the background flusher to make by FlushAndReset.
+	flushRequests chan chan error
+
+	// Closed when the client is closed.
+	closed chan struct{}
+
+	// Reconnects in the background after failed writes, if set.
+	reconnector *reconnector
+
+	// Keeps the last lines recorded, if set.
+	recent *recentLines
+
+	// Metrics waiting to be added to the buffer when WithAsync is used, and
+	// a channel that's closed to stop the goroutine that adds them.
+	queue     chan queued
+	queueDone chan struct{}
+
+	// Called with errors that can't be returned to the caller.
+	errorHandler func(error)
+
+	// Source of the current time and timers, replaced in tests.
+	clock clock
+
+	// Counters returned by Stats.
+	stats clientStats
+
+	// Last value sent for each gauge bucket. Only used when gauge
+	// de-duplication is enabled, in which case lastGauges is non-nil.
+	gaugeHeartbeat time.Duration
+	lastGauges     map[string]lastGauge
+	lastGaugesLock sync.Mutex
+
+	// Accumulates metrics between flushes for client-side aggregation. Each
+	// kind of aggregate is only kept when its option is used.
+	aggregator *aggregator
+}
+
+// tagProvider caches the tags returned by a provider function.
+type tagProvider struct {
+	sync.Mutex
+	provide func() []string
+	refresh time.Duration
+	tags    []string
+	updated time.Time
+}
+
+// get returns the cached tags, first refreshing them if they're older than the
+// refresh interval.
+func (p *tagProvider) get(now time.Time) []string {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.updated.IsZero() || now.Sub(p.updated) >= p.refresh {
+		p.tags = p.provide()
+		p.updated = now
+	}
+	return p.tags
+}
+
+// DefaultTags returns a copy of the tags currently added to every metric: those
+// passed to WithTags followed by those from the WithTagProvider provider, which
+// is called if its tags are due to be refreshed.
+func (c *statsdClient) DefaultTags() []string {
+	tags := append([]string(nil), c.tags...)
+	if c.tagProvider != nil {
+		tags = append(tags, c.tagProvider.get(c.clock.Now())...)
+	}
+	return tags
+}
+
+// prefixFunc caches the formatted result of a prefix function.
+type prefixFunc struct {
+	sync.Mutex
+	get    func() string
+	last   string
+	prefix []byte
+}
+
+// Prefix returns the prefix added to the names of metrics recorded now,
+// including its separator.
+func (c *statsdClient) Prefix() string {
+	return string(c.currentPrefix())
+}
+
+// SetPrefix changes the prefix added to the names of metrics recorded from now
+// on, followed by the separator, such as when a worker takes on a new role.
+// The connection and buffered metrics are kept, but metrics already in the
+// buffer keep their old prefix, since they've already been formatted. For a
+// client created WithPrefixFunc, it changes the prefix used in place of an
+// invalid one. Like the constructors, it returns an error wrapping
+// ErrInvalidPrefix if the prefix contains reserved characters.
+func (c *statsdClient) SetPrefix(prefix string) error {
+	validPrefix, err := c.validPrefix(prefix)
+	if err != nil {
+		return err
+	}
+
+	c.prefixLock.Lock()
+	c.prefix = []byte(validPrefix)
+	c.prefixLock.Unlock()
+	return nil
+}
+
+// validPrefix is makePrefix with the client's separator, first trimming
+// separators from the prefix WithTrimPrefixDot.
+func (c *statsdClient) validPrefix(prefix string) (string, error) {
+	if c.trimPrefix {
+		prefix = trimSeparators(prefix, c.prefixSeparator)
+	}
+	return makePrefix(prefix, c.prefixSeparator)
+}
+
+// basePrefix returns the prefix given to the constructor or SetPrefix.
+func (c *statsdClient) basePrefix() []byte {
+	c.prefixLock.RLock()
+	defer c.prefixLock.RUnlock()
+	return c.prefix
+}
+
+// currentPrefix returns the prefix for a metric being recorded now.
+func (c *statsdClient) currentPrefix() []byte {
+	p := c.prefixFunc
+	if p == nil {
+		return c.basePrefix()
+	}
+
+	raw := p.get()
+	p.Lock()
+	defer p.Unlock()
+	if p.prefix == nil || raw != p.last {
+		prefix, err := c.validPrefix(raw)
+		if err != nil {
+			c.handleError(err)
+			return c.basePrefix()
+		}
+		p.last = raw
+		p.prefix = []byte(prefix)
+	}
+	return p.prefix
+}
+
+type lastGauge struct {
+	value string
+	sent  time.Time
+}
+
+// record samples, formats and sends a metric, reporting whether it was sent.
+func (c *statsdClient) record(sampleRate float64, bucket, value, kind []byte, opts metricOptions) bool {
+	sampleRate, sampled := c.sample(sampleRate, 1)
+	if !sampled {
+		return false
+	}
+	line := c.prepare(sampleRate, bucket, value, kind, opts)
+	if line == nil {
+		return false
+	}
+
+	if opts.unbuffered {
+		c.sendUnbuffered(line)
+	} else {
+		c.send(line)
+	}
+	c.stats.sent.Add(1)
+	if opts.flush {
+		c.handleError(c.Flush())
+	}
+	return true
+}
+
+// recordMulti records the same formatted value in several buckets. The lines
+// are sampled together and sent together, so that they end up in the same
+// packet, unless together they're longer than a packet. It returns the
+// buckets that were sent.
+func (c *statsdClient) recordMulti(sampleRate float64, buckets []string, value, kind []byte, opts metricOptions) []string {
+	if len(buckets) == 0 {
+		return nil
+	}
+	sampleRate, sampled := c.sample(sampleRate, len(buckets))
+	if !sampled {
+		return nil
+	}
+
+	var lines [][]byte
+	var sent []string
+	size := 0
+	for _, bucket := range buckets {
+		if line := c.prepare(sampleRate, []byte(bucket), value, kind, opts); line != nil {
+			lines = append(lines, line)
+			sent = append(sent, bucket)
+			size += len(line) + len(c.buffer.lineSeparator())
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	c.buffer.Lock()
+	packetSize := c.PacketSize
+	c.unlockBuffer()
+	if packetSize > 0 && size > packetSize {
+		for _, line := range lines {
+			c.send(line)
+		}
+	} else {
+		c.send(bytes.Join(lines, c.buffer.lineSeparator()))
+	}
+	c.stats.sent.Add(uint64(len(lines)))
+	return sent
+}
+
+// sample replaces DefaultSampleRate with the client's default rate and reports
+// whether the metrics should be kept at that rate, counting them as sampled
+// out if not.
+func (c *statsdClient) sample(sampleRate float64, metrics int) (float64, bool) {
+	if sampleRate == DefaultSampleRate {
+		sampleRate = c.defaultSampleRate
+	}
+
+	// The top-level math/rand/v2 functions use a per-thread source, so
+	// sampling doesn't contend on a lock when called from many goroutines.
+	if sampleRate < 1 && sampleRate <= rand.Float64() {
+		c.stats.sampledOut.Add(uint64(metrics))
+		return sampleRate, false
+	}
+	return sampleRate, true
+}
+
+// prepare names, filters and formats a sampled metric line, returning nil and
+// counting the reason if it's dropped.
+func (c *statsdClient) prepare(sampleRate float64, bucket, value, kind []byte, opts metricOptions) []byte {
+	if c.nameStyle != NamesAsIs {
+		bucket = []byte(c.nameStyle.apply(string(bucket)))
+	}
+	if c.nameTransformer != nil {
+		bucket = []byte(c.nameTransformer(string(bucket)))
+	}
+	if c.suspicious != nil && !opts.unchecked {
+		c.checkBucket(string(bucket))
+	}
+	if c.filter != nil && !c.filter(string(bucket)) {
+		c.stats.filtered.Add(1)
+		return nil
+	}
+	if c.beforeSend != nil && !c.beforeSend(string(bucket), string(value), string(kind), sampleRate, opts.tags) {
+		c.stats.filtered.Add(1)
+		return nil
+	}
+
+	line := c.formatMetric(sampleRate, bucket, value, kind, opts)
+	if line == nil {
+		c.stats.invalid.Add(1)
+		return nil
+	}
+	if c.maxLineLength > 0 && c.lastLineLength(line) > c.maxLineLength {
+		opts.untagged = true
+		line = c.formatMetric(sampleRate, bucket, value, kind, opts)
+		if c.lastLineLength(line) > c.maxLineLength {
+			c.stats.tooLong.Add(1)
+			return nil
+		}
+		c.stats.tagsDropped.Add(1)
+	}
+	return line
+}
+
+// lastLineLength returns the length of the last line in a formatted metric,
+// which is the longest one when a negative gauge is preceded by a reset.
+func (c *statsdClient) lastLineLength(line []byte) int {
+	return len(line) - bytes.LastIndexByte(line, c.buffer.lineSeparator()[0]) - 1
+}
+
+// formatMetric builds a complete metric line, including the prefix, sample
+// rate, tags and timestamp.
+//
+// A negative gauge is preceded by a line setting the gauge to 0, since statsd
+// would otherwise treat the value as a decrement.
+//
+// It returns nil if the metric contains the line separator.
+func (c *statsdClient) formatMetric(sampleRate float64, bucket, value, kind []byte, opts metricOptions) []byte {
+	prefix := c.currentPrefix()
+	line := make([]byte, 0, len(prefix)+len(bucket)+len(value)+len(kind)+32)
+	if opts.negativeGauge {
+		line = c.appendMetric(line, prefix, sampleRate, bucket, []byte{'0'}, kind, opts)
+		line = append(line, c.buffer.lineSeparator()...)
+	}
+	start := len(line)
+	line = c.appendMetric(line, prefix, sampleRate, bucket, value, kind, opts)
+	if bytes.IndexByte(line[start:], c.buffer.lineSeparator()[0]) >= 0 {
+		return nil
+	}
+	return line
+}
+
+func (c *statsdClient) appendMetric(line, prefix []byte, sampleRate float64, bucket, value, kind []byte, opts metricOptions) []byte {
+	line = append(line, prefix...)
+	line = append(line, c.typePrefixes[string(kind)]...)
+	line = append(line, bucket...)
+	line = append(line, ':')
+	line = append(line, value...)
+	line = append(line, '|')
+	line = append(line, kind...)
+
+	if c.tagOrder == TagsBeforeRate && !opts.untagged {
+		line = c.appendTags(line, opts.tags)
+	}
+	if sampleRate != 1 {
+		line = append(line, '|', '@')
+		line = c.appendSampleRate(line, sampleRate)
+	}
+	if c.tagOrder == TagsAfterRate && !opts.untagged {
+		line = c.appendTags(line, opts.tags)
+	}
+	if c.timestamps && !opts.timestamp.IsZero() {
+		line = append(line, '|', 'T')
+		line = strconv.AppendInt(line, opts.timestamp.Unix(), 10)
+	}
+
+	return line
+}
+
+// appendSampleRate formats a sample rate without an exponent, which some
+// servers don't accept.
+func (c *statsdClient) appendSampleRate(line []byte, sampleRate float64) []byte {
+	if c.ratePrecision <= 0 {
+		return strconv.AppendFloat(line, sampleRate, 'f', -1, 64)
+	}
+
+	start := len(line)
+	line = strconv.AppendFloat(line, sampleRate, 'f', c.ratePrecision, 64)
+	rate := bytes.TrimRight(line[start:], "0")
+	rate = bytes.TrimSuffix(rate, []byte{'.'})
+	if string(rate) == "0" {
+		// A rate too small for the precision would be sent as 0, which
+		// servers can't scale by, so it's sent in full instead.
+		return strconv.AppendFloat(line[:start], sampleRate, 'f', -1, 64)
+	}
+	return line[:start+len(rate)]
+}
+
+// appendTags adds the default tags, the provided tags and the given per-metric
+// tags, in that order.
+func (c *statsdClient) appendTags(line []byte, tags []string) []byte {
+	var provided []string
+	if c.tagProvider != nil {
+		provided = c.tagProvider.get(c.clock.Now())
+	}
+
+	var scratch [16]string
+	merged := mergeTags(scratch[:0], c.tags, provided, tags)
+	if c.sortTags {
+		sort.Strings(merged)
+	}
+	for i, tag := range merged {
+		if i == 0 {
+			line = append(line, '|', '#')
+		} else {
+			line = append(line, ',')
+		}
+		line = append(line, tag...)
+	}
+	return line
+}
+
+// mergeTags appends the default, provided and per-call tags to merged in that
+// order, leaving out duplicate keys. A tag replaces an earlier one with the
+// same key in place, so the last value wins but the order of keys is kept. A
+// tag without a value is its own key.
+func mergeTags(merged, defaults, provided, tags []string) []string {
+	for _, set := range [...][]string{defaults, provided, tags} {
+	next:
+		for _, tag := range set {
+			key := tagKey(tag)
+			for i, existing := range merged {
+				if tagKey(existing) == key {
+					merged[i] = tag
+					continue next
+				}
+			}
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+// tagKey returns the part of a tag before the colon, or the whole tag if it
+// has no value.
+func tagKey(tag string) string {
+	if i := strings.IndexByte(tag, ':'); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+// send adds a metric line to the buffer, first flushing the buffer if the line
+// wouldn't fit in the current packet. When unbuffered, the line is written
+// straight to the connection instead.
+func (c *statsdClient) send(line []byte) {
+	if c.recent != nil {
+		c.recent.add(line, c.buffer.lineSeparator())
+	}
+	if c.queue != nil {
+		c.enqueue(queued{line: line})
+	} else {
+		c.sendNow(line)
+	}
+	c.touch()
+}
+
+// sendNow is send without the queue used by WithAsync.
+func (c *statsdClient) sendNow(line []byte) {
+	c.buffer.Lock()
+	defer c.unlockBuffer()
+
+	if c.PacketSize <= 0 {
+		c.handleLockedError(c.writeLine(line))
+		return
+	}
+
+	if c.bufferedSize(line) > c.PacketSize {
+		c.handleLockedError(c.forceFlush())
+	}
+	c.handleLockedError(c.addLine(line))
+}
+
+// sendUnbuffered writes a metric line straight to the connection, bypassing
+// the buffer and the queue used by WithAsync.
+func (c *statsdClient) sendUnbuffered(line []byte) {
+	if c.recent != nil {
+		c.recent.add(line, c.buffer.lineSeparator())
+	}
+	c.buffer.Lock()
+	defer c.unlockBuffer()
+	c.handleLockedError(c.writeLine(line))
+}
+
+// writeLine writes a line in a packet of its own. It must be called with the
+// buffer locked, so that it isn't interleaved with a flush.
+func (c *statsdClient) writeLine(line []byte) error {
+	if c.flushTimestamps {
+		line = c.stampLines(line)
+	}
+	line = append(line, c.packetEnd()...)
+	if c.disconnected() {
+		c.holdPacket(line)
+		return nil
+	}
+	err := c.write(line)
+	if err != nil {
+		c.writeFailed(err, line)
+	} else {
+		c.sendSpilled()
+	}
+	return err
+}
+
+// addLine adds a line to the buffer, which must have room for it unless it's
+// empty. A line longer than the packet size is sent whole in a packet of its
+// own, rather than being split, which could cut a tag in half. It must be
+// called with the buffer locked.
+func (c *statsdClient) addLine(line []byte) error {
+	c.buffer.addLine(line)
+	return c.lineAdded()
+}
+
+// lineAdded is called after a line is added to the buffer, to send it at once
+// if it made the buffer longer than the packet size. It must be called with
+// the buffer locked.
+func (c *statsdClient) lineAdded() error {
+	size := c.bufferedSize(nil)
+	c.stats.updateHighWater(size)
+	if size <= c.PacketSize {
+		return nil
+	}
+	c.stats.oversized.Add(1)
+	return c.forceFlush()
+}
+
+// forceFlush flushes the buffer because it's full. It must be called with the
+// buffer locked.
+func (c *statsdClient) forceFlush() error {
+	c.stats.forcedFlushes.Add(1)
+	return c.flush()
+}
+
+// Flush sends all buffered data to the statsd server, if there is any in the
+// buffer, and empties the buffer. Any client-side aggregates are added to the
+// buffer first.
+//
+// Concurrent calls to Flush are coalesced: a call made while another flush is
+// in progress waits for that flush and returns its result instead of writing
+// again. Clients created WithAsync don't coalesce flushes, since each one has
+// to wait for the metrics queued before it.
+func (c *statsdClient) Flush() error {
+	if c.queue != nil {
+		return c.flushAsync()
+	}
+
+	c.flushLock.Lock()
+	if call := c.flushing; call != nil {
+		c.flushLock.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &flushCall{done: make(chan struct{})}
+	c.flushing = call
+	c.flushLock.Unlock()
+
+	if c.flushTimestamps {
+		c.buffer.Lock()
+		c.flushTime = c.clock.Now()
+		c.unlockBuffer()
+	}
+
+	c.aggregator.emit(c)
+
+	c.buffer.Lock()
+	call.err = c.flushAll()
+	c.flushTime = time.Time{}
+
+	// Stop others from joining this flush before anything else can be added
+	// to the buffer, so that nobody waits on a flush that doesn't include
+	// their stats.
+	c.flushLock.Lock()
+	c.flushing = nil
+	c.flushLock.Unlock()
+	c.unlockBuffer()
+
+	close(call.done)
+	return call.err
+}
+
+// FlushIfPending is the same as Flush, but also reports whether anything was
+// sent, or held to be sent later while the client is disconnected. Flush
+// returns nil both when it sends a packet and when there's nothing to send,
+// which FlushIfPending tells apart, such as for tests waiting for a packet.
+// Packets sent by flushes made by other goroutines at the same time are
+// counted too.
+func (c *statsdClient) FlushIfPending() (bool, error) {
+	before := c.flushes.Load()
+	err := c.Flush()
+	return c.flushes.Load() != before, err
+}
+
+// Drain empties the buffer and returns the metric lines that were in it,
+// separated by newlines or the configured line separator, without sending
+// them. It can be used to save unsent metrics when shutting down, which can be
+// sent later through a LineWriter. Client-side aggregates that haven't been
+// flushed yet aren't included.
+func (c *statsdClient) Drain() []byte {
+	c.buffer.Lock()
+	defer c.unlockBuffer()
+
+	var lines []byte
+	if len(c.batch) > 0 {
+		lines = bytes.Join(c.batch, c.buffer.lineSeparator())
+		c.batch = c.batch[:0]
+	}
+	if c.buffer.size() > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, c.buffer.lineSeparator()...)
+		}
+		if c.buffer.vectored {
+			lines = append(lines, bytes.Join(c.buffer.lines, nil)...)
+		} else {
+			lines = append(lines, c.buffer.Bytes()...)
+		}
+		c.buffer.reset()
+	}
+	return lines
+}
+
+// flushCall is a Flush in progress that other callers can wait for.
+type flushCall struct {
+	done chan struct{}
+	err  error
+}
+
+// flush must be called with the buffer locked.
+func (c *statsdClient) flush() (err error) {
+	if c.buffer.size() > 0 {
+		c.flushes.Add(1)
+		if c.flushTimestamps {
+			stamped := c.stampLines(c.buffer.packet())
+			c.buffer.reset()
+			c.buffer.add(stamped)
+		}
+		if end := c.packetEnd(); end != nil {
+			c.buffer.add(end)
+		}
+		if c.disconnected() {
+			c.holdPacket(c.buffer.packet())
+			c.buffer.reset()
+			return nil
+		}
+
+		switch {
+		case c.batchSize > 0:
+			err = c.addToBatch(c.buffer.packet())
+		case c.buffer.vectored:
+			err = c.writeLines(c.buffer.lines, c.buffer.linesLen)
+		default:
+			err = c.write(c.buffer.Bytes())
+		}
+		switch {
+		case err == nil:
+			c.sendSpilled()
+		case c.batchSize > 0:
+			c.writeFailed(err, nil)
+		case errors.Is(err, syscall.EMSGSIZE):
+			err = c.resendSmaller(c.buffer.packet(), err)
+		default:
+			c.writeFailed(err, c.buffer.packet())
+		}
+		c.buffer.reset()
+	}
+	return err
+}
+
+// resendSmaller is called when packet was too big for the network, which
+// means the packet size is larger than the path MTU. It halves the size of
+// the packets it sends the lines in packet again in, until they're sent or
+// the packet that fails is a single line, which can't be split. Each
+// reduction is counted in Stats. The client's packet size isn't changed, so
+// later packets are only split if they fail too. Lines that can't be sent are
+// handled like any other failed write, and counted as a dropped packet if
+// they aren't held or spilled. It must be called with the buffer locked.
+func (c *statsdClient) resendSmaller(packet []byte, err error) error {
+	end := c.packetEnd()
+	separator := c.buffer.lineSeparator()
+	lines := bytes.Split(bytes.TrimSuffix(packet, end), separator)
+
+	size := c.PacketSize
+	for errors.Is(err, syscall.EMSGSIZE) && len(lines) > 1 && len(lines[0])+len(separator)+len(lines[1])+len(end) <= size {
+		size /= 2
+		c.stats.packetSizeReductions.Add(1)
+
+		var sent int
+		sent, err = c.writeGrouped(lines, separator, end, size)
+		lines = lines[sent:]
+	}
+	if err != nil {
+		kept := c.writeFailed(err, append(bytes.Join(lines, separator), end...))
+		if errors.Is(err, syscall.EMSGSIZE) && !kept {
+			c.stats.droppedPackets.Add(1)
+		}
+	}
+	return err
+}
+
+// writeGrouped writes lines in as few packets of up to size bytes as they fit
+// in, returning the number of lines sent before any error. A line longer than
+// size is sent in a packet of its own. It must be called with the buffer
+// locked.
+func (c *statsdClient) writeGrouped(lines [][]byte, separator, end []byte, size int) (int, error) {
+	sent := 0
+	for sent < len(lines) {
+		packet := append([]byte(nil), lines[sent]...)
+		n := 1
+		for sent+n < len(lines) && len(packet)+len(separator)+len(lines[sent+n])+len(end) <= size {
+			packet = append(append(packet, separator...), lines[sent+n]...)
+			n++
+		}
+		if err := c.write(append(packet, end...)); err != nil {
+			return sent, err
+		}
+		sent += n
+	}
+	return sent, nil
+}
+
+// Length of the timestamp added to each line by WithFlushTimestamps, which is
+// "|T" followed by a Unix time of up to 10 digits.
+const flushTimestampLen = 12
+
+// bufferedSize returns the size the buffered packet would be if line were
+// added to it, or the size it is if line is nil, including the separators
+// between lines and anything added when it's flushed. It must be called with
+// the buffer locked.
+func (c *statsdClient) bufferedSize(line []byte) int {
+	if line == nil {
+		return c.bufferedSizeWith(0, 0)
+	}
+	return c.bufferedSizeWith(len(line), 1+bytes.Count(line, c.buffer.lineSeparator()))
+}
+
+// bufferedSizeWith is bufferedSize for a line of length bytes made up of the
+// given number of metric lines, or for nothing if lines is 0.
+func (c *statsdClient) bufferedSizeWith(length, lines int) int {
+	separator := c.buffer.lineSeparator()
+	size := c.buffer.size()
+	if lines > 0 {
+		if size > 0 {
+			size += len(separator)
+		}
+		size += length
+	}
+	lines += c.buffer.lineCount
+	if c.flushTimestamps {
+		size += lines * flushTimestampLen
+	}
+	if c.trailingSeparator {
+		size += len(separator)
+	}
+	return size
+}
+
+// packetEnd returns what's added to the end of every packet: a newline on
+// stream networks, where it ends the last line, or the line separator
+// WithTrailingSeparator.
+func (c *statsdClient) packetEnd() []byte {
+	switch {
+	case c.isStream():
+		return newline
+	case c.trailingSeparator:
+		return c.buffer.lineSeparator()
+	}
+	return nil
+}
+
+// stampLines returns a copy of packet with the flush time added to every line
+// that doesn't already have a timestamp. It must be called with the buffer
+// locked.
+func (c *statsdClient) stampLines(packet []byte) []byte {
+	now := c.flushTime
+	if now.IsZero() {
+		now = c.clock.Now()
+	}
+
+	separator := c.buffer.lineSeparator()
+	lines := bytes.Split(packet, separator)
+	stamped := make([]byte, 0, len(packet)+len(lines)*flushTimestampLen)
+	for i, line := range lines {
+		if i > 0 {
+			stamped = append(stamped, separator...)
+		}
+		stamped = append(stamped, line...)
+		if !hasTimestamp(line) {
+			stamped = append(stamped, '|', 'T')
+			stamped = strconv.AppendInt(stamped, now.Unix(), 10)
+		}
+	}
+	return stamped
+}
+
+// hasTimestamp reports whether a metric line ends with a "|T" timestamp.
+func hasTimestamp(line []byte) bool {
+	i := bytes.LastIndexByte(line, '|')
+	if i < 0 || len(line) < i+3 || line[i+1] != 'T' {
+		return false
+	}
+	for _, b := range line[i+2:] {
+		if b < '0' || b > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// flushAll flushes the buffer and writes any batched packets. It must be
+// called with the buffer locked.
+func (c *statsdClient) flushAll() error {
+	err := c.flush()
+	if batchErr := c.writeBatch(); err == nil {
+		err = batchErr
+	}
+	return err
+}
+
+// writeLines sends a packet made up of several slices to the connection. If
+// the connection supports it, they're sent with a single vectored write
+// without being copied. Otherwise they're joined first. lines is left as it
+// was, so that the packet can still be held or resent if the write fails.
+func (c *statsdClient) writeLines(lines net.Buffers, size int) error {
+	switch c.conn.(type) {
+	case *net.UDPConn, *net.TCPConn, *net.UnixConn:
+		// WriteTo consumes the slices it writes.
+		bufs := append(net.Buffers(nil), lines...)
+		n, err := bufs.WriteTo(c.conn)
+		return checkWrite(int(n), size, err)
+	}
+	return c.write(bytes.Join(lines, nil))
+}
+
+// write sends a packet to the connection. Failed and short writes are reported
+// as a *WriteError; a short write wraps io.ErrShortWrite, since the rest of
+// the packet is lost.
+func (c *statsdClient) write(packet []byte) error {
+	n, err := c.conn.Write(packet)
+	return checkWrite(n, len(packet), err)
+}
+
+// isStream reports whether the client is connected over a stream network, in
+// which case each packet needs a trailing newline to separate it from the
+// next.
+func (c *statsdClient) isStream() bool {
+	switch c.network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return true
+	}
+	return false
+}
+
+func (c *statsdClient) flushLoop() {
+	timer := c.clock.NewTimer(c.nextFlushInterval())
+	for {
+		select {
+		case <-timer.C():
+			// Always flush, even if the buffer is nowhere near full.
+			c.handleError(c.Flush())
+			timer = c.clock.NewTimer(c.nextFlushInterval())
+		case reply := <-c.flushRequests:
+			timer.Stop()
+			err := c.Flush()
+			timer = c.clock.NewTimer(c.nextFlushInterval())
+			reply <- err
+		case <-c.done:
+			timer.Stop()
+			return
+		case <-c.flushContextDone():
+			timer.Stop()
+			c.handleError(c.Flush())
+			return
+		}
+	}
+}
+
+// flushContextDone returns the Done channel of the context given
+// WithFlushContext, or nil if there isn't one.
+func (c *statsdClient) flushContextDone() <-chan struct{} {
+	if c.flushContext == nil {
+		return nil
+	}
+	return c.flushContext.Done()
+}
+
+// flushOnDone flushes the buffer once the context given WithFlushContext is
+// done, for clients without a flush interval.
+func (c *statsdClient) flushOnDone() {
+	select {
+	case <-c.flushContext.Done():
+		c.handleError(c.Flush())
+	case <-c.closed:
+	}
+}
+
+// FlushAndReset flushes like Flush and restarts the background flush interval,
+// so that the next automatic flush is a full interval away instead of
+// following right after. When it returns, the next automatic flush has been
+// scheduled. It must not be called from the error handler, which the
+// background flusher may be waiting on. Without a flush interval, it's the
+// same as Flush.
+func (c *statsdClient) FlushAndReset() error {
+	if c.flushRequests == nil {
+		return c.Flush()
+	}
+
+	reply := make(chan error, 1)
+	select {
+	case c.flushRequests <- reply:
+		return <-reply
+	case <-c.done:
+		return c.Flush()
+	case <-c.flushContextDone():
+		return c.Flush()
+	}
+}
+
+func (c *statsdClient) nextFlushInterval() time.Duration {
+	if c.flushJitter <= 0 {
+		return c.flushInterval
+	}
+
+	interval := c.flushInterval - c.flushJitter + time.Duration(rand.Int64N(int64(2*c.flushJitter)+1))
+	if interval <= 0 {
+		return time.Millisecond
+	}
+	return interval
+}
+
+func (c *statsdClient) handleError(err error) {
+	if err != nil && c.errorHandler != nil {
+		c.errorHandler(err)
+	}
+}
+
+// handleLockedError is handleError for errors found with the buffer locked.
+// The error is reported by unlockBuffer, so that the error handler can record
+// metrics without deadlocking.
+func (c *statsdClient) handleLockedError(err error) {
+	if err != nil && c.errorHandler != nil {
+		c.lockedErrors = append(c.lockedErrors, err)
+	}
+}
+
+// unlockBuffer unlocks the buffer and then reports the errors passed to
+// handleLockedError while it was locked, and does any work on the spill file
+// that was queued.
+func (c *statsdClient) unlockBuffer() {
+	errs := c.lockedErrors
+	c.lockedErrors = nil
+	c.buffer.Unlock()
+	for _, err := range errs {
+		c.errorHandler(err)
+	}
+	if c.spill != nil && c.spill.work.Load() {
+		c.writeSpill()
+	}
+}
+
+// Close stops the background flusher, if any, flushes any buffered stats and
+// closes the connection to the statsd server. Calling Close again does nothing
+// and returns nil.
+func (c *statsdClient) Close() error {
+	first := false
+	c.closeOnce.Do(func() {
+		first = true
+		if c.done != nil {
+			close(c.done)
+		}
+		if c.closed != nil {
+			close(c.closed)
+		}
+	})
+	if !first {
+		return nil
+	}
+
+	err := c.Flush()
+	if c.queueDone != nil {
+		close(c.queueDone)
+	}
+
+	// The connection is replaced under the buffer lock when reconnecting.
+	c.buffer.Lock()
+	closeErr := c.conn.Close()
+	c.unlockBuffer()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// FlushOnSignal flushes and closes the client when the process receives one of
+// the given signals, such as syscall.SIGTERM, so that the last stats aren't
+// lost on shutdown. The signals are still delivered to any other handlers the
+// program has installed with signal.Notify. As with any call to signal.Notify,
+// the signals no longer stop the program by default, so the program should
+// handle them itself, for example with signal.NotifyContext.
+func (c *statsdClient) FlushOnSignal(sig ...os.Signal) {
+	flushOnSignal(c, sig)
+}
+
+func flushOnSignal(c Client, sig []os.Signal) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sig...)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		c.Close()
+	}()
+}
+
+// SetDestination changes the address, in "host:port" form, that stats are sent
+// to. It only works for clients created WithConnectionlessUDP.
+func (c *statsdClient) SetDestination(addr string) error {
+	c.buffer.Lock()
+	conn, ok := c.conn.(*packetConn)
+	c.unlockBuffer()
+	if !ok {
+		return errors.New("statsd: SetDestination requires WithConnectionlessUDP")
+	}
+
+	udpAddr, err := net.ResolveUDPAddr(c.network, addr)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrResolve, err)
+	}
+	conn.setDestination(udpAddr)
+	return nil
+}
+
+// Ping checks that the statsd server can be reached, waiting at most timeout.
+// It's meant for readiness checks.
+//
+// On stream networks like TCP, Ping opens a new connection to the server. On
+// UDP it can only send a "statsd.ping" counter and check that it was written
+// without an error. Since statsd servers don't reply to UDP packets, a
+// successful Ping doesn't prove anything is listening, although some systems
+// report an error once a packet has been refused.
+func (c *statsdClient) Ping(timeout time.Duration) error {
+	if c.isStream() {
+		host := c.host
+		if c.resolve != nil {
+			var err error
+			if host, err = c.resolve(); err != nil {
+				return fmt.Errorf("%w: %w", ErrResolve, err)
+			}
+		}
+		conn, err := net.DialTimeout(c.network, host, timeout)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrDial, err)
+		}
+		return conn.Close()
+	}
+
+	probe := c.formatMetric(1, []byte("statsd.ping"), []byte{'1'}, COUNT_FLAG, metricOptions{})
+
+	c.buffer.Lock()
+	defer c.unlockBuffer()
+	if err := c.conn.SetWriteDeadline(c.clock.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer c.conn.SetWriteDeadline(time.Time{})
+	return c.write(probe)
+}
+
+// Reconnect dials the statsd server again and replaces the current connection.
+// Buffered stats are kept and sent over the new connection.
+func (c *statsdClient) Reconnect() error {
+	connection, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	c.buffer.Lock()
+	old := c.conn
+	c.conn = connection
+	c.unlockBuffer()
+
+	return old.Close()
+}
+
+// Gauge sets an arbitrary value. Only the value of the gauge at flush time is
+// stored by statsd.
+//
+// Statsd treats a gauge value with a leading "-" as a decrement, so a negative
+// value is sent as two lines in the same packet, "bucket:0|g" followed by
+// "bucket:-5|g", which sets the gauge to the negative value.
+func (c *statsdClient) Gauge(bucket string, value float64, opts ...MetricOption) {
+	c.gauge(1, bucket, value, newMetricOptions(opts))
+}
+
+func (c *statsdClient) gauge(sampleRate float64, bucket string, value float64, opts metricOptions) {
+	valueString := strconv.FormatFloat(value, 'f', -1, 64)
+	if c.lastGauges != nil && c.isDuplicateGauge(bucket, valueString) {
+		return
+	}
+	opts.negativeGauge = value < 0
+	if c.record(sampleRate, []byte(bucket), []byte(valueString), GAUGE_FLAG, opts) && c.lastGauges != nil {
+		c.rememberGauge(bucket, valueString)
+	}
+}
+
+// GaugeMulti sets the same value in several gauges, such as under both the old
+// and new names of a metric while migrating between them. The value is
+// formatted once and the lines are added to the buffer together.
+func (c *statsdClient) GaugeMulti(value float64, buckets ...string) {
+	valueString := strconv.FormatFloat(value, 'f', -1, 64)
+	if c.lastGauges != nil {
+		var changed []string
+		for _, bucket := range buckets {
+			if !c.isDuplicateGauge(bucket, valueString) {
+				changed = append(changed, bucket)
+			}
+		}
+		buckets = changed
+	}
+	sent := c.recordMulti(1, buckets, []byte(valueString), GAUGE_FLAG, metricOptions{negativeGauge: value < 0})
+	if c.lastGauges != nil {
+		for _, bucket := range sent {
+			c.rememberGauge(bucket, valueString)
+		}
+	}
+}
+
+// GaugeBool sets a gauge to 1 if value is true and 0 if it's false, for states
+// like whether the process is the leader.
+func (c *statsdClient) GaugeBool(bucket string, value bool, opts ...MetricOption) {
+	c.Gauge(bucket, boolValue(value), opts...)
+}
+
+// GaugeState sets a gauge to a code for one of a small set of states, such as
+// 0 for stopped, 1 for starting and 2 for running.
+func (c *statsdClient) GaugeState(bucket string, state int, opts ...MetricOption) {
+	c.Gauge(bucket, float64(state), opts...)
+}
+
+// GaugeAndCount sets a gauge and increments a counter of how many times it has
+// been set, named "bucket.changes" by default.
+func (c *statsdClient) GaugeAndCount(bucket string, value float64, opts ...MetricOption) {
+	c.Gauge(bucket, value, opts...)
+	c.Count(bucket+c.changesSuffix, 1, 1, opts...)
+}
+
+// boolValue returns 1 for true and 0 for false.
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// GaugeDelta increases (or decreases, if delta is negative) the value of a
+// gauge, rather than setting it.
+func (c *statsdClient) GaugeDelta(bucket string, delta float64, opts ...MetricOption) {
+	value := make([]byte, 0, 24)
+	if delta >= 0 {
+		value = append(value, '+')
+	}
+	value = strconv.AppendFloat(value, delta, 'f', -1, 64)
+	c.record(1, []byte(bucket), value, GAUGE_FLAG, newMetricOptions(opts))
+}
+
+// GaugeDeltaString is the same as GaugeDelta except that the delta is sent
+// exactly as given, for servers that are particular about its format (eg. "+0"
+// rather than "0"). signedValue should start with "+" or "-", otherwise the
+// server will set the gauge to it instead.
+func (c *statsdClient) GaugeDeltaString(bucket string, signedValue string, opts ...MetricOption) {
+	c.record(1, []byte(bucket), []byte(signedValue), GAUGE_FLAG, newMetricOptions(opts))
+}
+
+// MinMaxGauge tracks the minimum and maximum of a value between flushes, which
+// are sent as "bucket.min" and "bucket.max" gauges when the client flushes.
+// This catches peaks, like the highest queue depth, that are lost when only
+// the last value of a gauge is kept.
+func (c *statsdClient) MinMaxGauge(bucket string, value float64, opts ...MetricOption) {
+	c.aggregator.addMinMaxGauge(bucket, value, newMetricOptions(opts).tags)
+}
+
+// isDuplicateGauge reports whether value was already sent for bucket within
+// the heartbeat interval.
+func (c *statsdClient) isDuplicateGauge(bucket, value string) bool {
+	c.lastGaugesLock.Lock()
+	defer c.lastGaugesLock.Unlock()
+
+	last, ok := c.lastGauges[bucket]
+	return ok && last.value == value && c.clock.Now().Sub(last.sent) < c.gaugeHeartbeat
+}
+
+// rememberGauge records value as the last value sent for bucket. It's only
+// called once the gauge has been added to the buffer, so that a gauge dropped
+// by sampling, a filter or validation doesn't suppress the next one.
+func (c *statsdClient) rememberGauge(bucket, value string) {
+	c.lastGaugesLock.Lock()
+	defer c.lastGaugesLock.Unlock()
+
+	c.lastGauges[bucket] = lastGauge{value: value, sent: c.clock.Now()}
+}
+
+// Count increments (or decrements) the value in a counter. Counters are
+// recorded and then reset to 0 when Statsd flushes. Pass DefaultSampleRate as
+// the sample rate to use the client's default.
+func (c *statsdClient) Count(bucket string, value float64, sampleRate float64, opts ...MetricOption) {
+	c.count(sampleRate, bucket, value, newMetricOptions(opts))
+}
+
+func (c *statsdClient) count(sampleRate float64, bucket string, value float64, opts metricOptions) {
+	if value < 0 {
+		switch c.negativeCounts {
+		case RejectNegativeCounts:
+			c.stats.negativeCounts.Add(1)
+			return
+		case DecrementNegativeCounts:
+			bucket += ".decrements"
+			value = -value
+		}
+	}
+
+	if c.aggregator.cumulativeCounts {
+		c.addCumulative(sampleRate, bucket, value, opts)
+		return
+	}
+	valueString := strconv.FormatFloat(value, 'f', -1, 64)
+	c.record(sampleRate, []byte(bucket), []byte(valueString), COUNT_FLAG, opts)
+}
+
+// CountMulti adds the same value to several counters, like GaugeMulti, with the
+// client's default sample rate. Negative values and cumulative counters are
+// handled for each bucket as Count does.
+func (c *statsdClient) CountMulti(value float64, buckets ...string) {
+	if value < 0 || c.aggregator.cumulativeCounts {
+		for _, bucket := range buckets {
+			c.count(DefaultSampleRate, bucket, value, metricOptions{})
+		}
+		return
+	}
+	valueString := strconv.FormatFloat(value, 'f', -1, 64)
+	c.recordMulti(DefaultSampleRate, buckets, []byte(valueString), COUNT_FLAG, metricOptions{})
+}
+
+// Increment adds 1 to a counter. It's the same as Count(bucket, 1,
+// DefaultSampleRate), but faster, since the value doesn't need to be
+// formatted. Without options, and unless the client was created with an
+// option that changes such a line, the line is appended straight to the
+// buffer without any allocations.
+func (c *statsdClient) Increment(bucket string, opts ...MetricOption) {
+	if len(opts) == 0 && c.incrementSuffix != nil && c.incrementFast(bucket) {
+		return
+	}
+	if c.aggregator.cumulativeCounts {
+		c.addCumulative(DefaultSampleRate, bucket, 1, newMetricOptions(opts))
+		return
+	}
+	c.record(DefaultSampleRate, []byte(bucket), one, COUNT_FLAG, newMetricOptions(opts))
+}
+
+// incrementFast appends an increment of bucket to the buffer, using the
+// suffix prepared when the client was created. It reports false, having done
+// nothing, if the line needs the general path, such as when the bucket is
+// invalid or the client sends each stat immediately.
+func (c *statsdClient) incrementFast(bucket string) bool {
+	prefix := c.currentPrefix()
+	countPrefix := c.typePrefixes[string(COUNT_FLAG)]
+	length := len(prefix) + len(countPrefix) + len(bucket) + len(c.incrementSuffix)
+	if c.maxLineLength > 0 && length > c.maxLineLength {
+		return false
+	}
+	if strings.IndexByte(bucket, c.buffer.lineSeparator()[0]) >= 0 {
+		return false
+	}
+
+	c.buffer.Lock()
+	defer c.unlockBuffer()
+	if c.PacketSize <= 0 {
+		return false
+	}
+	if c.bufferedSizeWith(length, 1) > c.PacketSize {
+		c.handleLockedError(c.forceFlush())
+	}
+	if c.buffer.size() > 0 {
+		c.buffer.Write(c.buffer.lineSeparator())
+	}
+	c.buffer.Write(prefix)
+	c.buffer.Write(countPrefix)
+	c.buffer.WriteString(bucket)
+	c.buffer.Write(c.incrementSuffix)
+	c.buffer.lineCount++
+	c.handleLockedError(c.lineAdded())
+
+	c.stats.sent.Add(1)
+	c.touch()
+	return true
+}
+
+// CountRate adds value to a counter that's aggregated on the client. When the
+// client flushes, the total is sent as a counter in bucket, along with its
+// rate per second as a gauge in "bucket.per_second", for systems that can't
+// calculate rates themselves. See WithRateInterval and WithRateSuffix.
+func (c *statsdClient) CountRate(bucket string, value float64, opts ...MetricOption) {
+	c.aggregator.addRate(bucket, value, newMetricOptions(opts).tags)
+}
+
+// Add is the same as Count with the client's default sample rate, which is 1
+// unless the client was created WithDefaultSampleRate.
+func (c *statsdClient) Add(bucket string, value float64, opts ...MetricOption) {
+	c.Count(bucket, value, DefaultSampleRate, opts...)
+}
+
+// Timing records a time interval (in milliseconds). The percentiles, mean,
+// standard deviation, sum, and lower and upper bounds are calculated by the
+// Statsd server.
+func (c *statsdClient) Timing(bucket string, value float64, opts ...MetricOption) {
+	c.timing(DefaultSampleRate, bucket, value, newMetricOptions(opts))
+}
+
+func (c *statsdClient) timing(sampleRate float64, bucket string, value float64, opts metricOptions) {
+	if c.aggregator.timings != nil {
+		c.aggregator.addTiming(bucket, value, opts.tags)
+		return
+	}
+
+	valueString := strconv.FormatFloat(value, 'f', -1, 64)
+	c.record(sampleRate, []byte(bucket), []byte(valueString), TIMING_FLAG, opts)
+}
+
+// TimingMulti records the same timing in several buckets, like GaugeMulti,
+// with the client's default sample rate. Timings aggregated on the client are
+// added to each bucket as Timing does.
+func (c *statsdClient) TimingMulti(value float64, buckets ...string) {
+	if c.aggregator.timings != nil {
+		for _, bucket := range buckets {
+			c.aggregator.addTiming(bucket, value, nil)
+		}
+		return
+	}
+	valueString := strconv.FormatFloat(value, 'f', -1, 64)
+	c.recordMulti(DefaultSampleRate, buckets, []byte(valueString), TIMING_FLAG, metricOptions{})
+}
+
+// TimingDuration is the same as Timing except that it takes a time.Duration
+// value.
+func (c *statsdClient) TimingDuration(bucket string, duration time.Duration, opts ...MetricOption) {
+	c.Timing(bucket, float64(duration)/float64(time.Millisecond), opts...)
+}
+
+// TimingMsInt is the same as Timing except that it takes a whole number of
+// milliseconds and a sample rate. Formatting an integer is cheaper than
+// formatting a float and never uses an exponent, so it's a good choice for
+// coarse timings recorded very often.
+func (c *statsdClient) TimingMsInt(bucket string, ms int64, sampleRate float64, opts ...MetricOption) {
+	o := newMetricOptions(opts)
+	if c.aggregator.timings != nil {
+		c.aggregator.addTiming(bucket, float64(ms), o.tags)
+		return
+	}
+
+	var valueBytes [20]byte
+	c.record(sampleRate, []byte(bucket), strconv.AppendInt(valueBytes[:0], ms, 10), TIMING_FLAG, o)
+}
+
+// TimeInMilliseconds records a timing given in milliseconds with a sample rate,
+// like Timing does. It's named like the equivalent method of other statsd
+// clients.
+func (c *statsdClient) TimeInMilliseconds(bucket string, ms float64, sampleRate float64, opts ...MetricOption) {
+	c.timing(sampleRate, bucket, ms, newMetricOptions(opts))
+}
+
+// Timer starts timing and returns a function that records the time elapsed
+// since Timer was called. It's convenient to defer:
+//
+//	defer client.Timer("request")()
+func (c *statsdClient) Timer(bucket string, opts ...MetricOption) func() {
+	start := c.clock.Now()
+	return func() {
+		c.TimingDuration(bucket, c.clock.Now().Sub(start), opts...)
+	}
+}
+
+// TimerWithTags is Timer with tags added to the timing, such as the route of
+// a request:
+//
+//	stop := client.TimerWithTags("request", "route:"+route)
+//	defer stop()
+func (c *statsdClient) TimerWithTags(bucket string, tags ...string) func() {
+	return c.Timer(bucket, MetricTags(tags...))
+}
+
+// Instrument calls f, records how long it took as a timing in
+// "bucket.duration" and counts the outcome in "bucket.success" or
+// "bucket.error". It returns the error returned by f.
+func (c *statsdClient) Instrument(bucket string, f func() error) error {
+	stop := c.Timer(bucket + ".duration")
+	err := f()
+	stop()
+
+	if err != nil {
+		c.Count(bucket+".error", 1, 1)
+	} else {
+		c.Count(bucket+".success", 1, 1)
+	}
+	return err
+}
+
+// Unique records the number of unique values received between flushes using
+// Statsd Sets. Runs of characters other than letters and digits in the value
+// are replaced with an underscore. Values without any letters or digits
+// aren't sent, since they would all be counted as the same value. Clients
+// created WithExactUniques count the values themselves instead.
+func (c *statsdClient) CountUnique(bucket string, value string, opts ...MetricOption) {
+	if c.aggregator.exactUniques {
+		c.aggregator.addSetValue(bucket, value, newMetricOptions(opts).tags)
+		return
+	}
+	if cleanValue := c.setValue(value); cleanValue != nil {
+		c.record(1, []byte(bucket), cleanValue, CARDINALITY_FLAG, newMetricOptions(opts))
+	}
+}
+
+// CountUniqueApprox estimates the number of distinct values received between
+// flushes on the client, for buckets with too many values to send each one,
+// and sends the estimate as a gauge in bucket when the client flushes. Values
+// aren't sanitized, since they're never sent. Unless the client was created
+// WithUniqueEstimates, it's the same as CountUnique.
+func (c *statsdClient) CountUniqueApprox(bucket, value string, opts ...MetricOption) {
+	if c.aggregator.uniquePrecision == 0 {
+		c.CountUnique(bucket, value, opts...)
+		return
+	}
+	c.aggregator.addUnique(bucket, value, newMetricOptions(opts).tags)
+}
+
+// cleanSetValue sanitizes a set value, returning nil if nothing but
+// separators is left.
+func cleanSetValue(value string) []byte {
+	cleanValue := NON_ALPHANUM.ReplaceAll([]byte(value), NON_ALPHANUM_REPLACE)
+	if len(bytes.Trim(cleanValue, "_")) == 0 {
+		return nil
+	}
+	return cleanValue
+}
+
+// Histogram records a value in a DogStatsD histogram, whose percentiles are
+// calculated by the agent. Like counts, histograms are sampled on the client
+// and sent with their sample rate so that the server can scale them.
+func (c *statsdClient) Histogram(bucket string, value float64, sampleRate float64, opts ...MetricOption) {
+	valueString := strconv.FormatFloat(value, 'f', -1, 64)
+	c.record(sampleRate, []byte(bucket), []byte(valueString), HISTOGRAM_FLAG, newMetricOptions(opts))
+}
+
+// HistogramBatch records several histogram values in one line, using the
+// DogStatsD form that separates values with colons: "bucket:1:2.5:3|h". The
+// line is sampled as a whole. Recent versions of the Datadog agent accept
+// this form, but other servers may not. Nothing is sent if values is empty.
+func (c *statsdClient) HistogramBatch(bucket string, values []float64, sampleRate float64, tags ...string) {
+	if len(values) == 0 {
+		return
+	}
+	value := make([]byte, 0, len(values)*8)
+	for i, v := range values {
+		if i > 0 {
+			value = append(value, ':')
+		}
+		value = strconv.AppendFloat(value, v, 'f', -1, 64)
+	}
+	c.record(sampleRate, []byte(bucket), value, HISTOGRAM_FLAG, metricOptions{tags: tags})
+}
+
+// HistogramBuckets counts value in cumulative histogram buckets, for
+// Prometheus-style histograms exported through a statsd bridge. It increments
+// "bucket.le_<bound>" for every bound that value is less than or equal to, and
+// always increments "bucket.le_inf". Periods in bounds are replaced with
+// underscores so that each bound is one segment of the name, so a value
+// counted under a bound of 0.5 is sent as "bucket.le_0_5". Bounds don't need
+// to be sorted.
+func (c *statsdClient) HistogramBuckets(bucket string, value float64, bounds []float64, opts ...MetricOption) {
+	for _, bound := range bounds {
+		if value <= bound {
+			c.Count(bucket+".le_"+formatBound(bound), 1, 1, opts...)
+		}
+	}
+	c.Count(bucket+".le_inf", 1, 1, opts...)
+}
+
+// formatBound formats a histogram bucket bound as a single name segment.
+func formatBound(bound float64) string {
+	return strings.ReplaceAll(strconv.FormatFloat(bound, 'f', -1, 64), ".", "_")
+}
+
+// Distribution records a value in a DogStatsD distribution, which is
+// aggregated globally by the server rather than per agent. It's sampled the
+// same way as Histogram.
+func (c *statsdClient) Distribution(bucket string, value float64, sampleRate float64, opts ...MetricOption) {
+	valueString := strconv.FormatFloat(value, 'f', -1, 64)
+	c.record(sampleRate, []byte(bucket), []byte(valueString), DISTRIBUTION_FLAG, newMetricOptions(opts))
+}
+
+// Observe records a metric of any type. It's useful for passing along metrics
+// that were built elsewhere, such as ones decoded from a queue.
+func (c *statsdClient) Observe(m Metric) {
+	sampleRate := m.SampleRate
+	if sampleRate == 0 {
+		switch m.Type {
+		case GaugeMetric, SetMetric:
+			sampleRate = 1
+		default:
+			sampleRate = DefaultSampleRate
+		}
+	}
+	valueString := []byte(strconv.FormatFloat(m.Value, 'f', -1, 64))
+	opts := metricOptions{tags: m.Tags, timestamp: m.Timestamp}
+
+	switch m.Type {
+	case CountMetric:
+		c.count(sampleRate, m.Bucket, m.Value, opts)
+	case GaugeMetric:
+		c.gauge(sampleRate, m.Bucket, m.Value, opts)
+	case TimingMetric:
+		c.timing(sampleRate, m.Bucket, m.Value, opts)
+	case SetMetric:
+		if c.aggregator.exactUniques {
+			c.aggregator.addSetValue(m.Bucket, m.SetValue, m.Tags)
+		} else if cleanValue := c.setValue(m.SetValue); cleanValue != nil {
+			c.record(sampleRate, []byte(m.Bucket), cleanValue, CARDINALITY_FLAG, opts)
+		}
+	case HistogramMetric:
+		c.record(sampleRate, []byte(m.Bucket), valueString, HISTOGRAM_FLAG, opts)
+	case DistributionMetric:
+		c.record(sampleRate, []byte(m.Bucket), valueString, DISTRIBUTION_FLAG, opts)
+	}
+}
+
+// SendRaw buffers an already formatted metric line, such as "bucket:1|c". The
+// line is sent as-is, without the prefix or default tags.
+func (c *statsdClient) SendRaw(line string) {
+	c.send([]byte(line))
+}
+
+// WriteBytes buffers a payload of one or more newline-separated metric lines,
+// such as a packet received by a statsd relay, without checking or
+// reformatting them. Lines are never split: a payload that doesn't fit in the
+// buffer is split between lines, and a client that sends each stat
+// immediately writes the whole payload as one packet. Unlike SendRaw, errors
+// writing to the server are returned.
+func (c *statsdClient) WriteBytes(p []byte) error {
+	var lines [][]byte
+	for _, line := range bytes.Split(p, newline) {
+		if line = bytes.TrimSuffix(line, []byte{'\r'}); len(line) > 0 {
+			lines = append(lines, bytes.Clone(line))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	if c.recent != nil {
+		for _, line := range lines {
+			c.recent.add(line, newline)
+		}
+	}
+
+	defer c.touch()
+	if c.queue != nil {
+		for _, line := range lines {
+			c.enqueue(queued{line: line})
+		}
+		return nil
+	}
+
+	c.buffer.Lock()
+	defer c.unlockBuffer()
+
+	if c.PacketSize <= 0 {
+		packet := bytes.Join(lines, c.buffer.lineSeparator())
+		if c.flushTimestamps {
+			packet = c.stampLines(packet)
+		}
+		packet = append(packet, c.packetEnd()...)
+		if c.disconnected() {
+			c.holdPacket(packet)
+			return nil
+		}
+		err := c.write(packet)
+		if err != nil {
+			c.writeFailed(err, packet)
+		}
+		return err
+	}
+
+	var err error
+	for _, line := range lines {
+		if c.bufferedSize(line) > c.PacketSize {
+			if flushErr := c.forceFlush(); err == nil {
+				err = flushErr
+			}
+		}
+		if addErr := c.addLine(line); err == nil {
+			err = addErr
+		}
+	}
+	return err
 }
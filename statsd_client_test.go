@@ -1,8 +1,24 @@
 package statsd
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"github.com/stvp/go-udp-testing"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -40,6 +56,172 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewErrors(t *testing.T) {
+	_, err := New("statsd://broken:9999")
+	if !errors.Is(err, ErrResolve) {
+		t.Errorf("Expected ErrResolve but got %#v", err)
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Errorf("Expected the error to wrap a *net.DNSError but got %#v", err)
+	}
+
+	_, err = New("statsd://localhost:99999")
+	if !errors.Is(err, ErrDial) {
+		t.Errorf("Expected ErrDial but got %#v", err)
+	}
+}
+
+func TestNewSRV(t *testing.T) {
+	client, err := NewSRV("_statsd._udp.broken.invalid", "app")
+	if !errors.Is(err, ErrResolve) {
+		t.Errorf("Expected ErrResolve but got %#v", err)
+	}
+	if reflect.TypeOf(client).String() != "*statsd.emptyClient" {
+		t.Fatal("A failed SRV lookup should return an emptyClient.")
+	}
+}
+
+func TestDialRetries(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client, err := New("statsd://"+addr, WithNetwork("tcp"), WithDialRetries(2, 100*time.Millisecond))
+	if !errors.Is(err, ErrDial) {
+		t.Fatalf("Expected ErrDial but got %#v", err)
+	}
+
+	// Reconnect only tries once.
+	start := time.Now()
+	if err := client.Reconnect(); !errors.Is(err, ErrDial) {
+		t.Errorf("Expected ErrDial but got %#v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("Expected Reconnect not to retry but it took %v", elapsed)
+	}
+
+	// The server comes up while the client is retrying.
+	started := make(chan net.Listener)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		listener, _ := net.Listen("tcp", addr)
+		started <- listener
+	}()
+	client, err = New("statsd://"+addr, WithNetwork("tcp"), WithDialRetries(100, 10*time.Millisecond))
+	if listener := <-started; listener != nil {
+		defer listener.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+}
+
+func TestEmptyClientReportsDisabled(t *testing.T) {
+	var errs []error
+	clock := newFakeClock()
+	client, _ := New("statsd://broken:9999", withClock(clock), WithErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+
+	// Discarded metrics are reported at most once a minute.
+	client.Count("a", 1, 1)
+	client.Gauge("b", 1)
+	client.Flush()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrDisabled) || !errors.Is(errs[0], ErrResolve) {
+		t.Fatalf("Expected one error wrapping ErrDisabled and ErrResolve but got %v", errs)
+	}
+	clock.Advance(time.Minute)
+	client.Timing("c", 1)
+	if len(errs) != 2 {
+		t.Errorf("Expected a second report after a minute but got %v", errs)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	client, _ := New("statsd://broken:9999")
+	if err := client.Reconnect(); err == nil {
+		t.Error("Reconnecting to a bad host should return an error.")
+	}
+
+	// An emptyClient that can reach its host starts sending stats.
+	client = &emptyClient{connect: func() (Client, error) {
+		return New("statsd://localhost:8125")
+	}}
+	udp.ShouldNotReceive(t, "bukkit", func() {
+		client.Gauge("bukkit", 1)
+		client.Flush()
+	})
+	if err := client.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	udp.ShouldReceiveOnly(t, "bukkit:2|g", func() {
+		client.Gauge("bukkit", 2)
+		client.Flush()
+	})
+
+	// A statsdClient keeps buffered stats across a reconnect.
+	client = goodClient("", 512)
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:2|c", func() {
+		client.Count("a", 1, 1)
+		if err := client.Reconnect(); err != nil {
+			t.Fatal(err)
+		}
+		client.Count("b", 2, 1)
+		client.Flush()
+	})
+}
+
+func TestConcurrentReconnect(t *testing.T) {
+	// Both calls create a client before either stores it.
+	var created []*statsdClient
+	var mu sync.Mutex
+	var dialing sync.WaitGroup
+	dialing.Add(2)
+	client := &emptyClient{connect: func() (Client, error) {
+		c, err := New("statsd://localhost:8125")
+		mu.Lock()
+		created = append(created, c.(*statsdClient))
+		mu.Unlock()
+		dialing.Done()
+		dialing.Wait()
+		return c, err
+	}}
+
+	var done sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			if err := client.Reconnect(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	done.Wait()
+
+	// Only one is kept, and the other is closed.
+	kept := client.connected()
+	for _, c := range created {
+		select {
+		case <-c.closed:
+			if Client(c) == kept {
+				t.Error("Expected the client in use not to be closed")
+			}
+		default:
+			if Client(c) != kept {
+				t.Error("Expected the extra client to be closed")
+			}
+		}
+	}
+}
+
 func TestGauge(t *testing.T) {
 	udp.SetAddr(":8125")
 	client := goodClient("", 512)
@@ -49,8 +231,9 @@ func TestGauge(t *testing.T) {
 		client.Gauge("bukkit", 2)
 		client.Flush()
 	})
-	// Negative numbers
-	udp.ShouldReceiveOnly(t, "bukkit:-12|g", func() {
+	// Negative numbers are set by resetting the gauge to 0 first, since a
+	// leading "-" alone is a decrement.
+	udp.ShouldReceiveOnly(t, "bukkit:0|g\nbukkit:-12|g", func() {
 		client.Gauge("bukkit", -12)
 		client.Flush()
 	})
@@ -65,6 +248,51 @@ func TestGauge(t *testing.T) {
 	})
 }
 
+func TestGaugeBoolAndState(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	udp.ShouldReceiveOnly(t, "leader:1|g\nhealthy:0|g\nphase:2|g", func() {
+		client.GaugeBool("leader", true)
+		client.GaugeBool("healthy", false)
+		client.GaugeState("phase", 2)
+		client.Flush()
+	})
+}
+
+func TestGaugeAndCount(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+	udp.ShouldReceiveOnly(t, "leader:1|g\nleader.changes:1|c", func() {
+		client.GaugeAndCount("leader", 1)
+		client.Flush()
+	})
+
+	client, _ = New("statsd://localhost:8125", WithChangesSuffix("_changed"))
+	udp.ShouldReceiveOnly(t, "leader:0|g\nleader_changed:1|c", func() {
+		client.GaugeAndCount("leader", 0)
+		client.Flush()
+	})
+}
+
+func TestGaugeDelta(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	udp.ShouldReceiveOnly(t, "bukkit:+2|g\nbukkit:-1.5|g\nbukkit:+0|g", func() {
+		client.GaugeDelta("bukkit", 2)
+		client.GaugeDelta("bukkit", -1.5)
+		client.GaugeDelta("bukkit", 0)
+		client.Flush()
+	})
+
+	udp.ShouldReceiveOnly(t, "bukkit:+0|g\nbukkit:-0.50|g", func() {
+		client.GaugeDeltaString("bukkit", "+0")
+		client.GaugeDeltaString("bukkit", "-0.50")
+		client.Flush()
+	})
+}
+
 func BenchmarkGaugeNoPrefix(b *testing.B) {
 	udp.SetAddr(":8125")
 	client := goodClient("", 512)
@@ -101,6 +329,37 @@ func BenchmarkGaugeWithPrefixNoBuffer(b *testing.B) {
 	}
 }
 
+func BenchmarkCountOne(b *testing.B) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		client.Count("metrics.are.cool", 1, 1)
+	}
+}
+
+func BenchmarkIncrement(b *testing.B) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		client.Increment("metrics.are.cool")
+	}
+}
+
+func BenchmarkSampledCountParallel(b *testing.B) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			client.Count("metrics.are.cool", 1, 0.01)
+		}
+	})
+}
+
 func TestCount(t *testing.T) {
 	udp.SetAddr(":8125")
 	client := goodClient("", 512)
@@ -127,6 +386,97 @@ func TestCount(t *testing.T) {
 	})
 }
 
+func TestNegativeCounts(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	client, _ := New("statsd://localhost:8125", WithNegativeCounts(RejectNegativeCounts))
+	udp.ShouldReceiveOnly(t, "a:2|c\nc:0|c", func() {
+		client.Count("a", 2, 1)
+		client.Count("b", -2, 1)
+		client.Observe(Metric{Type: CountMetric, Bucket: "b", Value: -1})
+		client.Count("c", 0, 1)
+		client.Flush()
+	})
+	if rejected := client.Stats().NegativeCounts; rejected != 2 {
+		t.Errorf("Expected 2 rejected counts but got %d", rejected)
+	}
+
+	client, _ = New("statsd://localhost:8125", WithNegativeCounts(DecrementNegativeCounts))
+	udp.ShouldReceiveOnly(t, "a:2|c\nb.decrements:2|c\nb.decrements:1.5|c|@0.999999", func() {
+		client.Count("a", 2, 1)
+		client.Add("b", -2)
+		client.Count("b", -1.5, 0.999999)
+		client.Flush()
+	})
+}
+
+func TestIncrement(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125/app", WithTags("env:test"))
+
+	udp.ShouldReceiveOnly(t, "app.bukkit:1|c|#env:test\napp.bukkit:1|c|#env:test,a:b", func() {
+		client.Increment("bukkit")
+		client.Increment("bukkit", MetricTags("a:b"))
+		client.Flush()
+	})
+
+	// Lines appended straight to the buffer are the same as any others.
+	client, _ = New("statsd://localhost:8125/app", WithTags("env:test", "env:prod"), WithTypePrefixes(map[MetricType]string{CountMetric: "counters."}))
+	if client.(*statsdClient).incrementSuffix == nil {
+		t.Fatal("Expected Increment to take the fast path")
+	}
+	udp.ShouldReceiveOnly(t, "app.counters.a:1|c|#env:prod\napp.counters.a:1|c|#env:prod", func() {
+		client.Increment("a")
+		client.Increment("a\nb")
+		client.Count("a", 1, 1)
+		client.Flush()
+	})
+
+	// The buffer is flushed first if the line doesn't fit.
+	client = goodClient("", 12)
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:1|c", func() {
+		client.Increment("a")
+		client.Increment("b")
+		client.Increment("c")
+	})
+	udp.ShouldReceiveOnly(t, "c:1|c", func() {
+		client.Flush()
+	})
+}
+
+func TestDefaultSampleRate(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithDefaultSampleRate(0.999999))
+
+	expected := "a:1|c|@0.999999\nb:1|c|@0.999999\nc:2|ms|@0.999999\nd:1|c|@0.999999\ne:1|c\nf:1|g"
+	udp.ShouldReceiveOnly(t, expected, func() {
+		client.Add("a", 1)
+		client.Increment("b")
+		client.Timing("c", 2)
+		client.Count("d", 1, DefaultSampleRate)
+		client.Count("e", 1, 1)
+		client.Gauge("f", 1)
+		client.Flush()
+	})
+
+	// Without the option, the default is 1.
+	client, _ = New("statsd://localhost:8125")
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client.Count("a", 1, DefaultSampleRate)
+		client.Flush()
+	})
+}
+
+func TestAdd(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	udp.ShouldReceiveOnly(t, "bukkit:3|c", func() {
+		client.Add("bukkit", 3)
+		client.Flush()
+	})
+}
+
 func TestPrefix(t *testing.T) {
 	udp.SetAddr(":8125")
 	client := goodClient("dude", 512)
@@ -137,6 +487,132 @@ func TestPrefix(t *testing.T) {
 	})
 }
 
+func TestSetPrefix(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("dude", 512)
+	if prefix := client.Prefix(); prefix != "dude." {
+		t.Errorf("Expected prefix %#v but got %#v", "dude.", prefix)
+	}
+
+	// Buffered metrics keep the prefix they were recorded with.
+	udp.ShouldReceiveOnly(t, "dude.a:1|c\nsweet.b:1|c", func() {
+		client.Count("a", 1, 1)
+		if err := client.SetPrefix("sweet"); err != nil {
+			t.Error(err)
+		}
+		client.Count("b", 1, 1)
+		client.Flush()
+	})
+	if prefix := client.Prefix(); prefix != "sweet." {
+		t.Errorf("Expected prefix %#v but got %#v", "sweet.", prefix)
+	}
+
+	if err := client.SetPrefix("bad:prefix"); !errors.Is(err, ErrInvalidPrefix) {
+		t.Errorf("Expected ErrInvalidPrefix but got %#v", err)
+	}
+	if prefix := client.Prefix(); prefix != "sweet." {
+		t.Errorf("Expected prefix %#v but got %#v", "sweet.", prefix)
+	}
+}
+
+func TestPrefixSeparator(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125/dude", WithPrefixSeparator("_"))
+
+	udp.ShouldReceiveOnly(t, "dude_bukkit:1|c", func() {
+		client.Count("bukkit", 1, 1)
+		client.Flush()
+	})
+}
+
+func TestTrimPrefixSeparators(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125/.app..web.", WithTrimPrefixDot())
+
+	udp.ShouldReceiveOnly(t, "app.web.bukkit:1|c", func() {
+		client.Count("bukkit", 1, 1)
+		client.Flush()
+	})
+
+	client.SetPrefix("worker..")
+	if prefix := client.Prefix(); prefix != "worker." {
+		t.Errorf("Expected %#v but got %#v", "worker.", prefix)
+	}
+}
+
+func TestPrefixFunc(t *testing.T) {
+	udp.SetAddr(":8125")
+	tenant := "acme"
+	var errs []error
+	client, _ := New("statsd://localhost:8125/app",
+		WithPrefixFunc(func() string { return tenant }),
+		WithErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	udp.ShouldReceiveOnly(t, "acme.a:1|c\nglobex.a:1|c\napp.a:1|c", func() {
+		client.Count("a", 1, 1)
+		tenant = "globex"
+		client.Count("a", 1, 1)
+		tenant = "bad tenant"
+		client.Count("a", 1, 1)
+		client.Flush()
+	})
+	if len(errs) != 1 || !errors.Is(errs[0], ErrInvalidPrefix) {
+		t.Errorf("Expected one ErrInvalidPrefix but got %#v", errs)
+	}
+}
+
+func TestTypePrefixes(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125/app", WithTypePrefixes(map[MetricType]string{
+		TimingMetric: "timers.",
+		CountMetric:  "counters.",
+	}))
+	udp.ShouldReceiveOnly(t, "app.timers.db.query:5|ms\napp.counters.hits:1|c\napp.depth:3|g", func() {
+		client.Timing("db.query", 5)
+		client.Count("hits", 1, 1)
+		client.Gauge("depth", 3)
+		client.Flush()
+	})
+}
+
+func TestNameTransformer(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125/Dude", WithNameTransformer(strings.ToLower))
+
+	udp.ShouldReceiveOnly(t, "Dude.cool.bukkit:1|c", func() {
+		client.Count("Cool.Bukkit", 1, 1)
+		client.Flush()
+	})
+}
+
+func TestNameStyle(t *testing.T) {
+	tests := map[string][2]string{
+		"RequestLatency":     {"request_latency", "request.latency"},
+		"api.HTTPRequests":   {"api.http_requests", "api.http.requests"},
+		"requestLatency":     {"request_latency", "request.latency"},
+		"Cache2Hits":         {"cache2_hits", "cache2.hits"},
+		"already_snake.case": {"already_snake.case", "already_snake.case"},
+	}
+	for bucket, expected := range tests {
+		if got := NamesSnakeCase.apply(bucket); got != expected[0] {
+			t.Errorf("Expected %#v but got %#v", expected[0], got)
+		}
+		if got := NamesDotted.apply(bucket); got != expected[1] {
+			t.Errorf("Expected %#v but got %#v", expected[1], got)
+		}
+		if got := NamesAsIs.apply(bucket); got != bucket {
+			t.Errorf("Expected %#v but got %#v", bucket, got)
+		}
+	}
+
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125/App", WithNameStyle(NamesSnakeCase))
+	udp.ShouldReceiveOnly(t, "App.request_latency:1|c", func() {
+		client.Count("RequestLatency", 1, 1)
+		client.Flush()
+	})
+}
+
 func TestTiming(t *testing.T) {
 	udp.SetAddr(":8125")
 	client := goodClient("", 512)
@@ -157,68 +633,232 @@ func TestTimingDuration(t *testing.T) {
 	})
 }
 
-func TestCountUnique(t *testing.T) {
+func TestTimingMsInt(t *testing.T) {
 	udp.SetAddr(":8125")
 	client := goodClient("", 512)
 
-	udp.ShouldReceiveOnly(t, "bukkit:foo|s", func() {
-		client.CountUnique("bukkit", "foo")
+	udp.ShouldReceiveOnly(t, "bukkit:9007199254740993|ms\nbukkit:12|ms|@0.999999", func() {
+		client.TimingMsInt("bukkit", 9007199254740993, 1)
+		client.TimingMsInt("bukkit", 12, 0.999999)
 		client.Flush()
 	})
+}
 
-	udp.ShouldReceiveOnly(t, "bukkit:foo_bar_1_baz_biz|s", func() {
-		client.CountUnique("bukkit", "foo:bar -1- baz|biz")
+func TestTimeInMilliseconds(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	udp.ShouldReceiveOnly(t, "a:1.5|ms\nb:250|ms|@0.999999", func() {
+		client.TimeInMilliseconds("a", 1.5, 1)
+		client.TimeInMilliseconds("b", 250, 0.999999)
 		client.Flush()
 	})
 }
 
-func TestFloatFormatting(t *testing.T) {
+func BenchmarkTimingMsInt(b *testing.B) {
 	udp.SetAddr(":8125")
 	client := goodClient("", 512)
 
-	udp.ShouldReceiveOnly(t, "foo:0.0000000000667428|g", func() {
-		client.Gauge("foo", 6.67428e-11)
-		client.Flush()
-	})
+	for i := 0; i < b.N; i++ {
+		client.TimingMsInt("metrics.are.cool", 98765, 1)
+	}
+}
 
-	udp.ShouldReceiveOnly(t, "foo:1.2345678901234567|g", func() {
-		client.Gauge("foo", 1.234567890123456789)
+func TestHistogramBuckets(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	bounds := []float64{0.1, 0.5, 1, 5}
+	udp.ShouldReceiveOnly(t, "latency.le_0_5:1|c\nlatency.le_1:1|c\nlatency.le_5:1|c\nlatency.le_inf:1|c", func() {
+		client.HistogramBuckets("latency", 0.5, bounds)
 		client.Flush()
 	})
-
-	udp.ShouldReceiveOnly(t, "foo:1234567000000000000|g", func() {
-		client.Gauge("foo", 1234567000000000000)
+	udp.ShouldReceiveOnly(t, "latency.le_inf:1|c", func() {
+		client.HistogramBuckets("latency", 10, bounds)
 		client.Flush()
 	})
 }
 
-func TestBuffer(t *testing.T) {
+func TestMulti(t *testing.T) {
 	udp.SetAddr(":8125")
+	client := goodClient("", 512)
 
-	udp.ShouldNotReceive(t, "b:2|c", func() {
-		client := goodClient("", 512)
-		client.Count("a", 1, 1)
+	udp.ShouldReceiveOnly(t, "old.a:0|g\nold.a:-1|g\nnew.a:0|g\nnew.a:-1|g\nold.b:2|c\nnew.b:2|c\nold.c:5|ms\nnew.c:5|ms", func() {
+		client.GaugeMulti(-1, "old.a", "new.a")
+		client.CountMulti(2, "old.b", "new.b")
+		client.TimingMulti(5, "old.c", "new.c")
+		client.CountMulti(1)
 		client.Flush()
-		client.Count("b", 2, 1)
-		client.Count("c", 3, 1)
 	})
+	if sent := client.Stats().Sent; sent != 6 {
+		t.Errorf("Expected 6 sent but got %d", sent)
+	}
 
-	udp.ShouldReceiveOnly(t, "a:1|c\nb:2|c\nc:3|c", func() {
-		client := goodClient("", 512)
-		client.Count("a", 1, 1)
-		client.Count("b", 2, 1)
-		client.Count("c", 3, 1)
+	// Counts and timings use the default sample rate, and the lines are
+	// sampled together.
+	client, _ = New("statsd://localhost:8125", WithDefaultSampleRate(0.5))
+	udp.ShouldReceiveOnly(t, "old.b:2|c|@0.5\nnew.b:2|c|@0.5\nold.c:5|ms|@0.5\nnew.c:5|ms|@0.5", func() {
+		records := []func(){
+			func() { client.CountMulti(2, "old.b", "new.b") },
+			func() { client.TimingMulti(5, "old.c", "new.c") },
+		}
+		for _, record := range records {
+			for {
+				before := client.Stats().Sent
+				record()
+				if sent := client.Stats().Sent - before; sent > 0 {
+					if sent != 2 {
+						t.Errorf("Expected both lines to be sent but got %d", sent)
+					}
+					break
+				}
+			}
+		}
 		client.Flush()
 	})
 
-	truncatedPacket := `four.score.and.seven.years.ago:0|c
-four.score.and.seven.years.ago:1|c
-four.score.and.seven.years.ago:2|c
-four.score.and.seven.years.ago:3|c
-four.score.and.seven.years.ago:4|c
-four.score.and.seven.years.ago:5|c
-four.score.and.seven.years.ago:6|c
-four.score.and.seven.years.ago:7|c
+	// Lines that don't fit in a packet together are sent separately.
+	client = goodClient("", 12)
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:1|c", func() {
+		client.CountMulti(1, "a", "b", "c")
+	})
+	udp.ShouldReceiveOnly(t, "c:1|c", func() {
+		client.Flush()
+	})
+}
+
+func TestHistogramBatch(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	udp.ShouldReceiveOnly(t, "latency:1:2.5:30|h|#route:/", func() {
+		client.HistogramBatch("latency", []float64{1, 2.5, 30}, 1, "route:/")
+		client.HistogramBatch("latency", nil, 1)
+		client.Flush()
+	})
+
+	// The line is sampled as a whole, with the rate before the tags.
+	udp.ShouldReceiveOnly(t, "latency:1:2|h|@0.5|#route:/", func() {
+		for {
+			before := client.Stats().Sent
+			client.HistogramBatch("latency", []float64{1, 2}, 0.5, "route:/")
+			if client.Stats().Sent > before {
+				break
+			}
+		}
+		client.Flush()
+	})
+}
+
+func TestHashedSetValues(t *testing.T) {
+	udp.SetAddr(":8125")
+	url := "https://example.com/a/very/long/path?with=query&parameters=1"
+	client, _ := New("statsd://localhost:8125", WithHashedSetValues(nil))
+
+	expected := "pages:" + FNVSetValue(url) + "|s\npages:" + FNVSetValue(url) + "|s"
+	udp.ShouldReceiveOnly(t, expected, func() {
+		client.CountUnique("pages", url)
+		client.Observe(Metric{Type: SetMetric, Bucket: "pages", SetValue: url})
+		client.Flush()
+	})
+	if hash := FNVSetValue(""); hash != "cbf29ce484222325" {
+		t.Errorf("Expected the FNV-1a offset basis but got %q", hash)
+	}
+
+	// A custom hash is sanitized like any other value.
+	client, _ = New("statsd://localhost:8125", WithHashedSetValues(func(value string) string {
+		return "len:" + strconv.Itoa(len(value))
+	}))
+	udp.ShouldReceiveOnly(t, "pages:len_3|s", func() {
+		client.CountUnique("pages", "abc")
+		client.Flush()
+	})
+}
+
+func TestCountUnique(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	udp.ShouldReceiveOnly(t, "bukkit:foo|s", func() {
+		client.CountUnique("bukkit", "foo")
+		client.Flush()
+	})
+
+	udp.ShouldReceiveOnly(t, "bukkit:foo_bar_1_baz_biz|s", func() {
+		client.CountUnique("bukkit", "foo:bar -1- baz|biz")
+		client.Flush()
+	})
+
+	// Unicode letters and digits are kept, including combining accents.
+	udp.ShouldReceiveOnly(t, "bukkit:café|s\nbukkit:cafe\u0301|s\nbukkit:東京_٣|s", func() {
+		client.CountUnique("bukkit", "café")
+		client.CountUnique("bukkit", "cafe\u0301")
+		client.CountUnique("bukkit", "東京 ٣")
+		client.Flush()
+	})
+
+	udp.ShouldReceiveOnly(t, "bukkit:party_|s", func() {
+		client.CountUnique("bukkit", "party🎉")
+		client.Flush()
+	})
+
+	// Values with nothing but separators aren't sent.
+	udp.ShouldReceiveOnly(t, "bukkit:ok|s", func() {
+		client.CountUnique("bukkit", "")
+		client.CountUnique("bukkit", "🎉🎉")
+		client.CountUnique("bukkit", "-:|")
+		client.CountUnique("bukkit", "ok")
+		client.Flush()
+	})
+}
+
+func TestFloatFormatting(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	udp.ShouldReceiveOnly(t, "foo:0.0000000000667428|g", func() {
+		client.Gauge("foo", 6.67428e-11)
+		client.Flush()
+	})
+
+	udp.ShouldReceiveOnly(t, "foo:1.2345678901234567|g", func() {
+		client.Gauge("foo", 1.234567890123456789)
+		client.Flush()
+	})
+
+	udp.ShouldReceiveOnly(t, "foo:1234567000000000000|g", func() {
+		client.Gauge("foo", 1234567000000000000)
+		client.Flush()
+	})
+}
+
+func TestBuffer(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	udp.ShouldNotReceive(t, "b:2|c", func() {
+		client := goodClient("", 512)
+		client.Count("a", 1, 1)
+		client.Flush()
+		client.Count("b", 2, 1)
+		client.Count("c", 3, 1)
+	})
+
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:2|c\nc:3|c", func() {
+		client := goodClient("", 512)
+		client.Count("a", 1, 1)
+		client.Count("b", 2, 1)
+		client.Count("c", 3, 1)
+		client.Flush()
+	})
+
+	truncatedPacket := `four.score.and.seven.years.ago:0|c
+four.score.and.seven.years.ago:1|c
+four.score.and.seven.years.ago:2|c
+four.score.and.seven.years.ago:3|c
+four.score.and.seven.years.ago:4|c
+four.score.and.seven.years.ago:5|c
+four.score.and.seven.years.ago:6|c
+four.score.and.seven.years.ago:7|c
 four.score.and.seven.years.ago:8|c
 four.score.and.seven.years.ago:9|c
 four.score.and.seven.years.ago:10|c
@@ -263,3 +903,847 @@ four.score.and.seven.years.ago:15|c`
 		// No flush needed
 	})
 }
+
+func TestBufferBoundary(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	// "a:1|c\nb:1|c" is exactly 11 bytes.
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:1|c", func() {
+		client := goodClient("", 11)
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 1)
+		client.Flush()
+	})
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client := goodClient("", 10)
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 1)
+	})
+}
+
+func TestTrailingSeparator(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	// The trailing separator counts towards the packet size.
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:1|c\n", func() {
+		client, _ := NewWithPacketSize("statsd://localhost:8125", 12, WithTrailingSeparator())
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 1)
+		client.Flush()
+	})
+	udp.ShouldReceiveOnly(t, "a:1|c\n", func() {
+		client, _ := NewWithPacketSize("statsd://localhost:8125", 11, WithTrailingSeparator())
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 1)
+	})
+
+	udp.ShouldReceiveOnly(t, "a:1|c;b:1|c;", func() {
+		client, _ := NewWithPacketSize("statsd://localhost:8125", 12, WithTrailingSeparator(), WithLineSeparator(';'))
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 1)
+		client.Flush()
+	})
+	udp.ShouldReceiveOnly(t, "a:1|c\n", func() {
+		client, _ := New("statsd://localhost:8125", WithUnbuffered(), WithTrailingSeparator())
+		client.Count("a", 1, 1)
+	})
+}
+
+func TestDrain(t *testing.T) {
+	udp.SetAddr(":8125")
+	for _, vectored := range []bool{false, true} {
+		options := []Option{}
+		if vectored {
+			options = append(options, WithVectoredWrites())
+		}
+		client, _ := New("statsd://localhost:8125", options...)
+		client.Count("a", 1, 1)
+		client.Gauge("b", 2)
+
+		lines := client.Drain()
+		if string(lines) != "a:1|c\nb:2|g" {
+			t.Errorf("Expected the buffered lines but got %#v", string(lines))
+		}
+		udp.ShouldNotReceive(t, "a:1", func() {
+			client.Flush()
+		})
+		if lines := client.Drain(); lines != nil {
+			t.Errorf("Expected nothing after draining but got %#v", string(lines))
+		}
+	}
+}
+
+func TestStartupPing(t *testing.T) {
+	udp.SetAddr(":8125")
+	udp.ShouldReceiveOnly(t, "app.statsd.client.started:1|c", func() {
+		New("statsd://localhost:8125/app", WithStartupPing("statsd.client.started"))
+	})
+}
+
+func TestUnbuffered(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	// Each stat is sent in its own packet.
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client, _ := New("statsd://localhost:8125", WithUnbuffered())
+		client.Count("a", 1, 1)
+		client.Count("b", 2, 1)
+	})
+}
+
+func TestFlushInterval(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	client, _ := New("statsd://localhost:8125", WithFlushInterval(10*time.Millisecond))
+	defer client.Close()
+
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client.Count("a", 1, 1)
+		// No flush needed
+	})
+}
+
+func TestFlushIntervalBelowPacketSize(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	client, _ := NewWithPacketSize("statsd://localhost:8125", 65000, WithFlushInterval(10*time.Millisecond))
+	defer client.Close()
+
+	// Each trickle of stats goes out on the next tick, long before the buffer
+	// would fill.
+	for _, bucket := range []string{"a", "b", "c"} {
+		udp.ShouldReceiveOnly(t, bucket+":1|c", func() {
+			client.Count(bucket, 1, 1)
+		})
+	}
+}
+
+func TestFlushJitter(t *testing.T) {
+	client := &statsdClient{flushInterval: time.Second, flushJitter: 100 * time.Millisecond}
+
+	for i := 0; i < 100; i++ {
+		interval := client.nextFlushInterval()
+		if interval < 900*time.Millisecond || interval > 1100*time.Millisecond {
+			t.Fatalf("Expected an interval within 1s +/- 100ms but got %s", interval)
+		}
+	}
+}
+
+func TestGaugeDedup(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithGaugeDedup(50*time.Millisecond))
+
+	udp.ShouldReceiveOnly(t, "a:1|g\na:2|g\na:1|g", func() {
+		client.Gauge("a", 1)
+		client.Gauge("a", 1)
+		client.Gauge("a", 2)
+		client.Gauge("a", 2)
+		client.Gauge("a", 1)
+		client.Flush()
+	})
+
+	// Unchanged values are sent again after the heartbeat.
+	time.Sleep(50 * time.Millisecond)
+	udp.ShouldReceiveOnly(t, "a:1|g", func() {
+		client.Gauge("a", 1)
+		client.Gauge("a", 1)
+		client.Flush()
+	})
+}
+
+func TestGaugeDedupDropped(t *testing.T) {
+	udp.SetAddr(":8125")
+	drop := true
+	client, _ := New("statsd://localhost:8125", WithGaugeDedup(time.Minute),
+		WithBeforeSend(func(bucket, value, kind string, rate float64, tags []string) bool {
+			return !drop
+		}))
+
+	// A gauge dropped before it's buffered doesn't suppress the next one.
+	udp.ShouldReceiveOnly(t, "a:1|g\nb:1|g", func() {
+		client.Gauge("a", 1)
+		client.GaugeMulti(1, "b")
+		drop = false
+		client.Gauge("a", 1)
+		client.GaugeMulti(1, "b")
+		client.Flush()
+	})
+}
+
+func TestTags(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	client, _ := New("statsd://localhost:8125", WithTags("env:test", "canary"))
+	udp.ShouldReceiveOnly(t, "a:1|c|#env:test,canary\nb:1|c|@0.999999|#env:test,canary", func() {
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 0.999999)
+		client.Flush()
+	})
+
+	client, _ = New("statsd://localhost:8125", WithTags("env:test"), WithTagOrder(TagsBeforeRate))
+	udp.ShouldReceiveOnly(t, "a:1|c|#env:test\nb:1|c|#env:test|@0.999999", func() {
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 0.999999)
+		client.Flush()
+	})
+}
+
+func TestBuildTag(t *testing.T) {
+	udp.SetAddr(":8125")
+	t.Setenv("STATSD_VERSION", "fromenv")
+
+	client, _ := New("statsd://localhost:8125", WithTags("env:test"), WithBuildTag("abc123"))
+	udp.ShouldReceiveOnly(t, "a:1|c|#env:test,version:abc123", func() {
+		client.Count("a", 1, 1)
+		client.Flush()
+	})
+
+	// The environment is used when the version isn't set at build time.
+	client, _ = New("statsd://localhost:8125", WithBuildTag(""))
+	udp.ShouldReceiveOnly(t, "a:1|c|#version:fromenv", func() {
+		client.Count("a", 1, 1)
+		client.Flush()
+	})
+}
+
+func TestRateAndTagFormatting(t *testing.T) {
+	udp.SetAddr(":8125")
+
+	// Every combination of {rate 1, rate below 1} and {no tags, tags}, with
+	// tags in either position. A rate of 1 is never sent.
+	tests := []struct {
+		order    TagOrder
+		expected string
+	}{
+		{TagsAfterRate, "a:1|c\nb:1|c|@0.999999\nc:1|c|#k:v\nd:1|c|@0.999999|#k:v"},
+		{TagsBeforeRate, "a:1|c\nb:1|c|@0.999999\nc:1|c|#k:v\nd:1|c|#k:v|@0.999999"},
+	}
+	for _, test := range tests {
+		client, _ := New("statsd://localhost:8125", WithTagOrder(test.order))
+		udp.ShouldReceiveOnly(t, test.expected, func() {
+			client.Count("a", 1, 1)
+			client.Count("b", 1, 0.999999)
+			client.Count("c", 1, 1, MetricTags("k:v"))
+			client.Count("d", 1, 0.999999, MetricTags("k:v"))
+			client.Flush()
+		})
+	}
+}
+
+func TestSampleRateFormatting(t *testing.T) {
+	tests := []struct {
+		precision int
+		rate      float64
+		expected  string
+	}{
+		{0, 0.5, "0.5"},
+		{0, 0.00001, "0.00001"},
+		{0, 0.3333333, "0.3333333"},
+		{3, 0.3333333, "0.333"},
+		{3, 0.5, "0.5"},
+		{3, 0.0001, "0.0001"},
+		{2, 0.999, "1"},
+	}
+	for _, test := range tests {
+		client := &statsdClient{ratePrecision: test.precision}
+		if got := string(client.appendSampleRate(nil, test.rate)); got != test.expected {
+			t.Errorf("Expected %v at precision %d to be %#v but got %#v", test.rate, test.precision, test.expected, got)
+		}
+	}
+}
+
+func TestMetricTags(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithTags("env:test"))
+	udp.ShouldReceiveOnly(t, "a:1|c|#env:test,route:home", func() {
+		client.Count("a", 1, 1, MetricTags("route:home"))
+		client.Flush()
+	})
+}
+
+func TestDuplicateTags(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithTags("env:test", "region:us", "canary"))
+
+	// Later values replace earlier ones in place.
+	udp.ShouldReceiveOnly(t, "a:1|c|#env:prod,region:us,canary,route:home", func() {
+		client.Count("a", 1, 1, MetricTags("route:home", "env:staging", "env:prod"))
+		client.Flush()
+	})
+	udp.ShouldReceiveOnly(t, "a:1|c|#env:test,region:us,canary:false", func() {
+		client.Count("a", 1, 1, MetricTags("canary:false"))
+		client.Flush()
+	})
+}
+
+func TestSortedTags(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithTags("zone:b", "env:test"), WithSortedTags())
+
+	// The same tags produce the same line however they're given.
+	for _, tags := range [][]string{{"route:home", "app:web"}, {"app:web", "route:home", "zone:b"}} {
+		udp.ShouldReceiveOnly(t, "a:1|c|#app:web,env:test,route:home,zone:b", func() {
+			client.Count("a", 1, 1, MetricTags(tags...))
+			client.Flush()
+		})
+	}
+}
+
+func TestObserve(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithTags("env:test"))
+
+	expected := "a:1|c|#env:test\n" +
+		"b:2|g|#env:test,role:web\n" +
+		"c:3|ms|@0.999999|#env:test\n" +
+		"d:foo_bar|s|#env:test\n" +
+		"e:4|h|#env:test\n" +
+		"f:5|d|#env:test"
+	udp.ShouldReceiveOnly(t, expected, func() {
+		client.Observe(Metric{Type: CountMetric, Bucket: "a", Value: 1})
+		client.Observe(Metric{Type: GaugeMetric, Bucket: "b", Value: 2, Tags: []string{"role:web"}})
+		client.Observe(Metric{Type: TimingMetric, Bucket: "c", Value: 3, SampleRate: 0.999999})
+		client.Observe(Metric{Type: SetMetric, Bucket: "d", SetValue: "foo:bar"})
+		client.Observe(Metric{Type: HistogramMetric, Bucket: "e", Value: 4})
+		client.Observe(Metric{Type: DistributionMetric, Bucket: "f", Value: 5})
+		client.Flush()
+	})
+}
+
+func TestTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := New("statsd://"+listener.Addr().String(), WithNetwork("tcp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Count("a", 1, 1)
+	client.Flush()
+	client.Count("b", 1, 1)
+	client.Close()
+
+	expectStream(t, listener, "a:1|c\nb:1|c\n")
+}
+
+func TestWriteBytes(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("ignored", 15)
+
+	// Payloads are split between lines to fit the packet size.
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:2|g", func() {
+		if err := client.WriteBytes([]byte("a:1|c\nb:2|g\r\n\nc:3|ms\n")); err != nil {
+			t.Error(err)
+		}
+	})
+	udp.ShouldReceiveOnly(t, "c:3|ms\nd:4|c", func() {
+		client.WriteBytes([]byte("d:4|c"))
+		client.Flush()
+	})
+
+	client = goodClient("", 0)
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:2|g", func() {
+		if err := client.WriteBytes([]byte("a:1|c\nb:2|g\n")); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestPing(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("app", 512)
+	udp.ShouldReceiveOnly(t, "app.statsd.ping:1|c", func() {
+		if err := client.Ping(time.Second); err != nil {
+			t.Error(err)
+		}
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = New("statsd://"+listener.Addr().String(), WithNetwork("tcp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(time.Second); err != nil {
+		t.Error(err)
+	}
+
+	listener.Close()
+	if err := client.Ping(time.Second); !errors.Is(err, ErrDial) {
+		t.Errorf("Expected ErrDial but got %#v", err)
+	}
+	client.Close()
+}
+
+func TestFlushOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Sending os.Interrupt isn't supported on Windows")
+	}
+
+	// Keep the signal from stopping the test once the client's handler is
+	// removed.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+	client.FlushOnSignal(os.Interrupt)
+	client.Count("a", 1, 1)
+
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		process, _ := os.FindProcess(os.Getpid())
+		if err := process.Signal(os.Interrupt); err != nil {
+			t.Fatal(err)
+		}
+		<-signals
+		time.Sleep(10 * time.Millisecond)
+	})
+}
+
+func TestTLS(t *testing.T) {
+	// Borrow httptest's certificate, which is valid for 127.0.0.1.
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	certificate := server.TLS.Certificates[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	server.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		client, err := New("statsd://"+listener.Addr().String(), WithTLS(&tls.Config{RootCAs: roots}))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		client.Count("a", 1, 1)
+		client.Close()
+	}()
+
+	expectStream(t, listener, "a:1|c\n")
+}
+
+// expectStream accepts a single connection on listener and checks that
+// everything read from it matches expected.
+func expectStream(t *testing.T, listener net.Listener, expected string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	got, _ := io.ReadAll(conn)
+	if string(got) != expected {
+		t.Errorf("Expected %#v but got %#v", expected, string(got))
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	udp.SetAddr(":8125")
+	timestamp := time.Unix(1700000000, 0)
+
+	client, _ := New("statsd://localhost:8125", WithTags("env:test"), WithTimestamps())
+	udp.ShouldReceiveOnly(t, "a:1|c|#env:test|T1700000000\nb:1|c|#env:test", func() {
+		client.Observe(Metric{Type: CountMetric, Bucket: "a", Value: 1, Timestamp: timestamp})
+		client.Observe(Metric{Type: CountMetric, Bucket: "b", Value: 1})
+		client.Flush()
+	})
+
+	// Timestamps aren't sent to plain statsd servers.
+	client, _ = New("statsd://localhost:8125")
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client.Observe(Metric{Type: CountMetric, Bucket: "a", Value: 1, Timestamp: timestamp})
+		client.Flush()
+	})
+}
+
+// bufferConn records the size of its send buffer.
+type bufferConn struct {
+	net.Conn
+	size int
+}
+
+func (c *bufferConn) SetWriteBuffer(size int) error {
+	c.size = size
+	return nil
+}
+
+func TestWriteBufferSize(t *testing.T) {
+	udp.SetAddr(":8125")
+	for _, option := range []Option{WithUnbuffered(), WithConnectionlessUDP()} {
+		client, err := New("statsd://localhost:8125", option, WithWriteBufferSize(1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		udp.ShouldReceiveOnly(t, "a:1|c", func() {
+			client.Count("a", 1, 1)
+			client.Flush()
+		})
+		client.Close()
+	}
+
+	conn := &bufferConn{}
+	if err := setWriteBuffer(conn, 1<<20); err != nil || conn.size != 1<<20 {
+		t.Errorf("Expected a 1MB buffer but got %d (%v)", conn.size, err)
+	}
+}
+
+func TestFlushTimestamps(t *testing.T) {
+	udp.SetAddr(":8125")
+	clock := newFakeClock()
+	client, _ := New("statsd://localhost:8125", withClock(clock), WithFlushTimestamps())
+
+	// Lines are stamped when they're flushed, not when they're recorded.
+	udp.ShouldReceiveOnly(t, "a:1|c|T1700000005\nb:0|g|T1700000005\nb:-1|g|T1700000005\nc:1|c|T1600000000", func() {
+		client.Count("a", 1, 1)
+		client.Gauge("b", -1)
+		client.Observe(Metric{Type: CountMetric, Bucket: "c", Value: 1, Timestamp: time.Unix(1600000000, 0)})
+		clock.Advance(5 * time.Second)
+		client.Flush()
+	})
+
+	// Room is left in each packet for the timestamps.
+	client, _ = NewWithPacketSize("statsd://localhost:8125", 30, withClock(clock), WithFlushTimestamps())
+	udp.ShouldReceiveOnly(t, "a:1|c|T1700000005", func() {
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 1)
+	})
+
+	client, _ = NewWithPacketSize("statsd://localhost:8125", -1, withClock(clock), WithFlushTimestamps())
+	udp.ShouldReceiveOnly(t, "a:1|c|T1700000005", func() {
+		client.Count("a", 1, 1)
+	})
+}
+
+// shortConn is a net.Conn that only ever writes half of what it's given.
+type shortConn struct {
+	net.Conn
+}
+
+func (c shortConn) Write(p []byte) (int, error) {
+	return len(p) / 2, nil
+}
+
+func TestShortWrite(t *testing.T) {
+	client := newStatsdClient(512, nil)
+	client.conn = shortConn{}
+	client.Count("a", 1, 1)
+	err := client.Flush()
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("Expected io.ErrShortWrite but got %#v", err)
+	}
+	var writeErr *WriteError
+	if !errors.As(err, &writeErr) || writeErr.BytesWritten != 2 || writeErr.BytesRemaining != 3 {
+		t.Errorf("Expected 2 bytes written and 3 remaining but got %#v", err)
+	}
+
+	var handled error
+	client = newStatsdClient(0, []Option{WithErrorHandler(func(err error) { handled = err })})
+	client.conn = shortConn{}
+	client.Count("a", 1, 1)
+	if !errors.Is(handled, io.ErrShortWrite) {
+		t.Errorf("Expected io.ErrShortWrite but got %#v", handled)
+	}
+}
+
+func TestErrorHandlerRecordsMetric(t *testing.T) {
+	var client *statsdClient
+	var handled []error
+	client = newStatsdClient(12, []Option{WithErrorHandler(func(err error) {
+		handled = append(handled, err)
+		if len(handled) == 1 {
+			client.Count("errors", 1, 1)
+		}
+	})})
+	client.conn = shortConn{}
+
+	// The handler is called after the buffer is unlocked, so recording a
+	// metric from it doesn't deadlock.
+	done := make(chan struct{})
+	go func() {
+		client.Count("a", 1, 1)
+		client.Count("b", 1, 1)
+		client.Count("c", 1, 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Recording a metric from the error handler deadlocked")
+	}
+	if len(handled) == 0 || !errors.Is(handled[0], io.ErrShortWrite) {
+		t.Errorf("Expected io.ErrShortWrite but got %#v", handled)
+	}
+}
+
+// failingConn is a net.Conn whose writes write some bytes and then fail.
+type failingConn struct {
+	net.Conn
+	err error
+}
+
+func (c failingConn) Write(p []byte) (int, error) {
+	return 1, c.err
+}
+
+func TestWriteError(t *testing.T) {
+	opErr := &net.OpError{Op: "write", Net: "tcp", Err: errors.New("broken pipe")}
+	client := newStatsdClient(512, nil)
+	client.conn = failingConn{err: opErr}
+	client.Count("a", 1, 1)
+	err := client.Flush()
+
+	var writeErr *WriteError
+	if !errors.As(err, &writeErr) || writeErr.BytesWritten != 1 || writeErr.BytesRemaining != 4 {
+		t.Errorf("Expected 1 byte written and 4 remaining but got %#v", err)
+	}
+	var netErr *net.OpError
+	if !errors.As(err, &netErr) || netErr != opErr {
+		t.Errorf("Expected the error to unwrap to %#v but got %#v", opErr, err)
+	}
+}
+
+// mtuConn is a net.Conn that fails to write packets larger than mtu with
+// EMSGSIZE, like a UDP socket does.
+type mtuConn struct {
+	net.Conn
+	mtu     int
+	packets []string
+}
+
+func (c *mtuConn) Write(p []byte) (int, error) {
+	if len(p) > c.mtu {
+		return 0, &net.OpError{Op: "write", Net: "udp", Err: syscall.EMSGSIZE}
+	}
+	c.packets = append(c.packets, string(p))
+	return len(p), nil
+}
+
+func TestMessageTooLong(t *testing.T) {
+	conn := &mtuConn{mtu: 600}
+	client := newStatsdClient(2048, nil)
+	client.conn = conn
+
+	var lines []string
+	for i := 0; i < 40; i++ {
+		bucket := "some.fairly.long.bucket.name." + strconv.Itoa(i)
+		client.Count(bucket, 1, 1)
+		lines = append(lines, bucket+":1|c")
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The packet is halved twice, and every line is sent exactly once, but
+	// the client's packet size is unchanged.
+	if client.PacketSize != 2048 {
+		t.Errorf("Expected a packet size of 2048 but got %d", client.PacketSize)
+	}
+	if reductions := client.Stats().PacketSizeReductions; reductions != 2 {
+		t.Errorf("Expected 2 reductions but got %d", reductions)
+	}
+	if sent := strings.Join(conn.packets, "\n"); sent != strings.Join(lines, "\n") {
+		t.Errorf("Expected every line once but got %#v", conn.packets)
+	}
+	for _, packet := range conn.packets {
+		if len(packet) > 512 {
+			t.Errorf("Expected packets of at most 512 bytes but got %d", len(packet))
+		}
+	}
+
+	// Once the packet can't be split any more, the error is returned and the
+	// line is dropped.
+	conn.mtu = 3
+	client.Count("a", 1, 1)
+	if err := client.Flush(); !errors.Is(err, syscall.EMSGSIZE) {
+		t.Errorf("Expected EMSGSIZE but got %#v", err)
+	}
+	if dropped := client.Stats().DroppedPackets; dropped != 1 {
+		t.Errorf("Expected 1 dropped packet but got %d", dropped)
+	}
+}
+
+func TestMessageTooLongDefaultSize(t *testing.T) {
+	conn := &mtuConn{mtu: 300}
+	client := newStatsdClient(512, nil)
+	client.conn = conn
+
+	var lines []string
+	for i := 0; i < 20; i++ {
+		bucket := "a.bucket.name." + strconv.Itoa(i)
+		client.Count(bucket, 1, 1)
+		lines = append(lines, bucket+":1|c")
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if sent := strings.Join(conn.packets, "\n"); sent != strings.Join(lines, "\n") {
+		t.Errorf("Expected every line once but got %#v", conn.packets)
+	}
+	if stats := client.Stats(); stats.PacketSizeReductions != 1 || client.PacketSize != 512 {
+		t.Errorf("Expected 1 reduction of a packet of 512 bytes but got %d of %d", stats.PacketSizeReductions, client.PacketSize)
+	}
+
+	// A line that can't be split is spilled rather than lost.
+	path := filepath.Join(t.TempDir(), "statsd.spill")
+	client = newStatsdClient(512, []Option{WithSpillFile(path, 1024)})
+	client.conn = &mtuConn{mtu: 3}
+	client.spill.after = 1
+	client.Count("a", 1, 1)
+	if err := client.Flush(); !errors.Is(err, syscall.EMSGSIZE) {
+		t.Errorf("Expected EMSGSIZE but got %#v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "a:1|c\n" {
+		t.Errorf("Expected the line to be spilled but got %q", data)
+	}
+	if stats := client.Stats(); stats.Spilled != 1 || stats.DroppedPackets != 0 {
+		t.Errorf("Expected 1 spilled and none dropped but got %#v", stats)
+	}
+}
+
+// slowConn is a net.Conn whose writes block until release is closed.
+type slowConn struct {
+	net.Conn
+	started chan struct{}
+	release chan struct{}
+	writes  int32
+}
+
+func (c *slowConn) Write(p []byte) (int, error) {
+	if atomic.AddInt32(&c.writes, 1) == 1 {
+		close(c.started)
+	}
+	<-c.release
+	return len(p), nil
+}
+
+func TestFlushCoalescing(t *testing.T) {
+	conn := &slowConn{started: make(chan struct{}), release: make(chan struct{})}
+	client := newStatsdClient(512, nil)
+	client.conn = conn
+	client.Count("a", 1, 1)
+
+	var wg sync.WaitGroup
+	flush := func() {
+		defer wg.Done()
+		if err := client.Flush(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	wg.Add(1)
+	go flush()
+	<-conn.started
+	for i := 0; i < 9; i++ {
+		wg.Add(1)
+		go flush()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(conn.release)
+	wg.Wait()
+
+	if conn.writes != 1 {
+		t.Errorf("Expected 1 write but got %d", conn.writes)
+	}
+}
+
+func TestFlushIfPending(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client.Count("a", 1, 1)
+		if sent, err := client.FlushIfPending(); !sent || err != nil {
+			t.Errorf("Expected the buffer to be sent but got %v, %v", sent, err)
+		}
+	})
+	if sent, err := client.FlushIfPending(); sent || err != nil {
+		t.Errorf("Expected nothing to be sent but got %v, %v", sent, err)
+	}
+}
+
+func TestFlushImmediately(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:1|g", func() {
+		client.Count("a", 1, 1)
+		client.Gauge("b", 1, FlushImmediately())
+		client.Count("c", 1, 1)
+	})
+}
+
+func TestUnbufferedOption(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("", 512)
+
+	// The metric is written on its own, and what's buffered stays buffered.
+	udp.ShouldReceiveOnly(t, "shutting_down:1|g", func() {
+		client.Count("a", 1, 1)
+		client.Gauge("shutting_down", 1, Unbuffered())
+	})
+	udp.ShouldReceiveOnly(t, "a:1|c", func() {
+		client.Flush()
+	})
+}
+
+func TestVectoredWrites(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := NewWithPacketSize("statsd://localhost:8125", 20, WithVectoredWrites())
+
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:2|c\nc:3|c", func() {
+		client.Count("a", 1, 1)
+		client.Count("b", 2, 1)
+		client.Count("c", 3, 1)
+		client.Count("d", 4, 1)
+	})
+	udp.ShouldReceiveOnly(t, "d:4|c", func() {
+		client.Flush()
+	})
+
+	// The buffered lines are left as they were by the write, so that they can
+	// be resent if it fails.
+	c := client.(*statsdClient)
+	c.Count("e", 5, 1)
+	c.buffer.Lock()
+	udp.ShouldReceiveOnly(t, "e:5|c", func() {
+		if err := c.writeLines(c.buffer.lines, c.buffer.linesLen); err != nil {
+			t.Error(err)
+		}
+	})
+	if packet := string(c.buffer.packet()); packet != "e:5|c" {
+		t.Errorf("Expected the buffer to be unchanged but got %q", packet)
+	}
+	c.buffer.Unlock()
+}
+
+func benchmarkFlush(b *testing.B, options ...Option) {
+	udp.SetAddr(":8125")
+	client, _ := NewWithPacketSize("statsd://localhost:8125", 1432, options...)
+
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 30; j++ {
+			client.Gauge("metrics.are.cool", 98765.4321)
+		}
+		client.Flush()
+	}
+}
+
+func BenchmarkFlushContiguous(b *testing.B) {
+	benchmarkFlush(b)
+}
+
+func BenchmarkFlushVectored(b *testing.B) {
+	benchmarkFlush(b, WithVectoredWrites())
+}
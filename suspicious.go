@@ -0,0 +1,51 @@
+package statsd
+
+import (
+	"regexp"
+)
+
+// Bucket names that look like they were built from values instead of being
+// fixed: a leftover format verb, a run of digits such as an ID or timestamp,
+// a UUID or a long hex string such as a hash.
+var suspiciousBucket = regexp.MustCompile(`%|[0-9]{6,}|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{24,}`)
+
+// SuspiciousBucket reports whether a bucket name looks like it contains
+// something other than a fixed name, such as an unformatted "%v", a user ID or
+// a UUID, which would create a new series for every value. It's the default
+// check used by WithSuspiciousBuckets.
+func SuspiciousBucket(bucket string) bool {
+	return suspiciousBucket.MatchString(bucket)
+}
+
+// WithSuspiciousBuckets checks the name of every metric recorded, after any
+// name transformer, to catch names that would create too many series before
+// they run up costs. Metrics whose name check reports as suspicious are still
+// sent, but report is called with the name. If check is nil, SuspiciousBucket
+// is used. If report is nil, "statsd.suspicious_bucket" is counted instead,
+// without the name, which would itself create too many series.
+func WithSuspiciousBuckets(check func(bucket string) bool, report func(bucket string)) Option {
+	if check == nil {
+		check = SuspiciousBucket
+	}
+	return func(c *statsdClient) {
+		c.suspicious = check
+		c.reportSuspicious = report
+	}
+}
+
+// checkBucket reports bucket if it's suspicious.
+func (c *statsdClient) checkBucket(bucket string) {
+	if !c.suspicious(bucket) {
+		return
+	}
+	if c.reportSuspicious != nil {
+		c.reportSuspicious(bucket)
+		return
+	}
+	// The counter isn't checked itself, since a check that flags it, perhaps
+	// after a name transformer has renamed it, would count it forever.
+	c.record(1, []byte(suspiciousBucketCounter), one, COUNT_FLAG, metricOptions{unchecked: true})
+}
+
+// Counted for suspicious bucket names when there's no report function.
+const suspiciousBucketCounter = "statsd.suspicious_bucket"
@@ -0,0 +1,64 @@
+package statsd
+
+import (
+	"github.com/stvp/go-udp-testing"
+	"testing"
+)
+
+func TestSuspiciousBucket(t *testing.T) {
+	suspicious := []string{
+		"users.%v.logins",
+		"users.1234567.logins",
+		"jobs.3f2504e0-4f89-11d3-9a0c-0305e82c3301.duration",
+		"blobs.5d41402abc4b2a76b9719d911017c592.size",
+	}
+	for _, bucket := range suspicious {
+		if !SuspiciousBucket(bucket) {
+			t.Errorf("Expected %#v to be suspicious", bucket)
+		}
+	}
+	for _, bucket := range []string{"users.logins", "http.status.404", "db.shard12.query", "cache.deadbeef"} {
+		if SuspiciousBucket(bucket) {
+			t.Errorf("Expected %#v not to be suspicious", bucket)
+		}
+	}
+}
+
+func TestSuspiciousBuckets(t *testing.T) {
+	udp.SetAddr(":8125")
+	client, _ := New("statsd://localhost:8125", WithSuspiciousBuckets(nil, nil))
+
+	// The metric is still sent, after the counter.
+	udp.ShouldReceiveOnly(t, "statsd.suspicious_bucket:1|c\nusers.1234567.logins:1|c\nusers.logins:1|c", func() {
+		client.Count("users.1234567.logins", 1, 1)
+		client.Count("users.logins", 1, 1)
+		client.Flush()
+	})
+
+	var reported []string
+	client, _ = New("statsd://localhost:8125", WithSuspiciousBuckets(func(bucket string) bool {
+		return len(bucket) > 10
+	}, func(bucket string) {
+		reported = append(reported, bucket)
+	}))
+	udp.ShouldReceiveOnly(t, "a.very.long.name:1|c\nshort:1|c", func() {
+		client.Count("a.very.long.name", 1, 1)
+		client.Count("short", 1, 1)
+		client.Flush()
+	})
+	if len(reported) != 1 || reported[0] != "a.very.long.name" {
+		t.Errorf("Expected the long name to be reported but got %#v", reported)
+	}
+
+	// The counter is counted once even if every name, including the
+	// counter's after it's transformed, is suspicious.
+	client, _ = New("statsd://localhost:8125", WithNameTransformer(func(bucket string) string {
+		return "app." + bucket
+	}), WithSuspiciousBuckets(func(bucket string) bool {
+		return true
+	}, nil))
+	udp.ShouldReceiveOnly(t, "app.statsd.suspicious_bucket:1|c\napp.a:1|c", func() {
+		client.Count("a", 1, 1)
+		client.Flush()
+	})
+}
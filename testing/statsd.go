@@ -1,39 +1,379 @@
 package statsd
 
 import (
+	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/stvp/gostatsd"
 )
 
+var _ statsd.Client = (*MockStatsdClient)(nil)
+
 // Satisfies the StatsReporter interface to make testing easier.
 type MockStatsdClient struct {
 	Counts  map[string]string
 	Gauges  map[string]string
 	Timings map[string]string
+
+	// Every metric recorded, in order.
+	Calls []Call
+
+	// Lines passed to SendRaw, in order.
+	RawLines []string
+
+	// FlushErr is returned by Flush, if set.
+	FlushErr error
+
+	// Prefix set with SetPrefix. It isn't added to recorded metrics.
+	prefix string
+
+	// Number of calls made for each metric type.
+	countCalls  int
+	gaugeCalls  int
+	timingCalls int
+	setCalls    int
+	flushCalls  int
+
+	// Number of calls and raw lines recorded at the last flush.
+	flushedCalls int
+}
+
+// Call is a single metric recorded by the mock.
+type Call struct {
+	Type       statsd.MetricType
+	Bucket     string
+	Value      string
+	SampleRate float64
+	Tags       []string
+}
+
+// CallsTo returns the metrics recorded for bucket, in order.
+func (c *MockStatsdClient) CallsTo(bucket string) []Call {
+	var calls []Call
+	for _, call := range c.Calls {
+		if call.Bucket == bucket {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (c *MockStatsdClient) record(kind statsd.MetricType, bucket, value string, sampleRate float64, opts []statsd.MetricOption) {
+	c.Calls = append(c.Calls, Call{
+		Type:       kind,
+		Bucket:     bucket,
+		Value:      value,
+		SampleRate: sampleRate,
+		Tags:       statsd.TagsOf(opts...),
+	})
+}
+
+// FlushCalls returns the number of times Flush was called.
+func (c *MockStatsdClient) FlushCalls() int {
+	return c.flushCalls
+}
+
+// CountCalls returns the number of counts recorded.
+func (c *MockStatsdClient) CountCalls() int {
+	return c.countCalls
+}
+
+// GaugeCalls returns the number of gauges recorded.
+func (c *MockStatsdClient) GaugeCalls() int {
+	return c.gaugeCalls
+}
+
+// TimingCalls returns the number of timings recorded, including those recorded
+// with TimingDuration and TimingMsInt.
+func (c *MockStatsdClient) TimingCalls() int {
+	return c.timingCalls
+}
+
+// SetCalls returns the number of CountUnique calls.
+func (c *MockStatsdClient) SetCalls() int {
+	return c.setCalls
 }
 
 func (c *MockStatsdClient) Flush() error {
+	c.flushCalls++
+	c.flushedCalls = len(c.Calls) + len(c.RawLines)
+	return c.FlushErr
+}
+
+// FlushIfPending is counted as a call to Flush. It reports whether any metrics
+// or raw lines were recorded since the last flush.
+func (c *MockStatsdClient) FlushIfPending() (bool, error) {
+	pending := len(c.Calls)+len(c.RawLines) > c.flushedCalls
+	return pending, c.Flush()
+}
+
+// FlushAndReset is counted as a call to Flush.
+func (c *MockStatsdClient) FlushAndReset() error {
+	return c.Flush()
+}
+
+func (c *MockStatsdClient) Drain() []byte {
 	return nil
 }
 
-func (c *MockStatsdClient) Count(bucket string, value, sampleRate float64) {
+func (c *MockStatsdClient) Reconnect() error {
+	return nil
+}
+
+func (c *MockStatsdClient) SetDestination(addr string) error {
+	return nil
+}
+
+func (c *MockStatsdClient) Ping(timeout time.Duration) error {
+	return nil
+}
+
+func (c *MockStatsdClient) FlushOnSignal(sig ...os.Signal) {
+}
+
+func (c *MockStatsdClient) Close() error {
+	return nil
+}
+
+func (c *MockStatsdClient) Count(bucket string, value, sampleRate float64, opts ...statsd.MetricOption) {
 	valueString := strconv.FormatFloat(value, 'f', -1, 64)
 	c.Counts[bucket] = valueString
+	c.countCalls++
+	c.record(statsd.CountMetric, bucket, valueString, sampleRate, opts)
 }
 
-func (c *MockStatsdClient) Gauge(bucket string, value float64) {
-	valueString := strconv.FormatFloat(value, 'f', -1, 64)
-	c.Gauges[bucket] = valueString
+// Increment records a count of 1 like Count does.
+func (c *MockStatsdClient) Increment(bucket string, opts ...statsd.MetricOption) {
+	c.Count(bucket, 1, 1, opts...)
 }
 
-func (c *MockStatsdClient) Timing(bucket string, value float64) {
-	valueString := strconv.FormatFloat(value, 'f', -1, 64)
-	c.Timings[bucket] = valueString
+// CountRate records a count like Count does.
+func (c *MockStatsdClient) CountRate(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.Count(bucket, value, 1, opts...)
+}
+
+func (c *MockStatsdClient) Add(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.Count(bucket, value, 1, opts...)
+}
+
+func (c *MockStatsdClient) Gauge(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.gauge(bucket, strconv.FormatFloat(value, 'f', -1, 64), 1, opts)
+}
+
+// gauge records any gauge, whatever its sample rate.
+func (c *MockStatsdClient) gauge(bucket, value string, sampleRate float64, opts []statsd.MetricOption) {
+	c.Gauges[bucket] = value
+	c.gaugeCalls++
+	c.record(statsd.GaugeMetric, bucket, value, sampleRate, opts)
+}
+
+func (c *MockStatsdClient) GaugeBool(bucket string, value bool, opts ...statsd.MetricOption) {
+	if value {
+		c.Gauge(bucket, 1, opts...)
+	} else {
+		c.Gauge(bucket, 0, opts...)
+	}
+}
+
+func (c *MockStatsdClient) GaugeState(bucket string, state int, opts ...statsd.MetricOption) {
+	c.Gauge(bucket, float64(state), opts...)
+}
+
+// GaugeAndCount records a gauge and a count of "bucket.changes".
+func (c *MockStatsdClient) GaugeAndCount(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.Gauge(bucket, value, opts...)
+	c.Count(bucket+".changes", 1, 1, opts...)
+}
+
+func (c *MockStatsdClient) GaugeDelta(bucket string, delta float64, opts ...statsd.MetricOption) {
+	valueString := strconv.FormatFloat(delta, 'f', -1, 64)
+	if delta >= 0 {
+		valueString = "+" + valueString
+	}
+	c.GaugeDeltaString(bucket, valueString, opts...)
 }
 
-func (c *MockStatsdClient) TimingDuration(bucket string, value time.Duration) {
-	c.Timing(bucket, float64(value)/float64(time.Millisecond))
+// MinMaxGauge records the value like Gauge does.
+func (c *MockStatsdClient) MinMaxGauge(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.Gauge(bucket, value, opts...)
 }
 
-func (c *MockStatsdClient) CountUnique(bucket, value string) {
+func (c *MockStatsdClient) GaugeDeltaString(bucket string, signedValue string, opts ...statsd.MetricOption) {
+	c.gauge(bucket, signedValue, 1, opts)
+}
+
+func (c *MockStatsdClient) Timing(bucket string, value float64, opts ...statsd.MetricOption) {
+	c.timing(statsd.TimingMetric, bucket, strconv.FormatFloat(value, 'f', -1, 64), 1, opts)
+}
+
+// timing records any metric that's stored with the timings.
+func (c *MockStatsdClient) timing(kind statsd.MetricType, bucket, value string, sampleRate float64, opts []statsd.MetricOption) {
+	c.Timings[bucket] = value
+	c.timingCalls++
+	c.record(kind, bucket, value, sampleRate, opts)
+}
+
+func (c *MockStatsdClient) TimingDuration(bucket string, value time.Duration, opts ...statsd.MetricOption) {
+	c.Timing(bucket, float64(value)/float64(time.Millisecond), opts...)
+}
+
+func (c *MockStatsdClient) TimingMsInt(bucket string, ms int64, sampleRate float64, opts ...statsd.MetricOption) {
+	c.timing(statsd.TimingMetric, bucket, strconv.FormatInt(ms, 10), sampleRate, opts)
+}
+
+func (c *MockStatsdClient) TimeInMilliseconds(bucket string, ms, sampleRate float64, opts ...statsd.MetricOption) {
+	c.timing(statsd.TimingMetric, bucket, strconv.FormatFloat(ms, 'f', -1, 64), sampleRate, opts)
+}
+
+func (c *MockStatsdClient) Timer(bucket string, opts ...statsd.MetricOption) func() {
+	start := time.Now()
+	return func() {
+		c.TimingDuration(bucket, time.Since(start), opts...)
+	}
+}
+
+func (c *MockStatsdClient) TimerWithTags(bucket string, tags ...string) func() {
+	return c.Timer(bucket, statsd.MetricTags(tags...))
+}
+
+func (c *MockStatsdClient) Instrument(bucket string, f func() error) error {
+	stop := c.Timer(bucket + ".duration")
+	err := f()
+	stop()
+
+	if err != nil {
+		c.Count(bucket+".error", 1, 1)
+	} else {
+		c.Count(bucket+".success", 1, 1)
+	}
+	return err
+}
+
+func (c *MockStatsdClient) CountUnique(bucket, value string, opts ...statsd.MetricOption) {
+	c.setCalls++
+	c.record(statsd.SetMetric, bucket, value, 1, opts)
+}
+
+// CountUniqueApprox records the value like CountUnique does.
+func (c *MockStatsdClient) CountUniqueApprox(bucket, value string, opts ...statsd.MetricOption) {
+	c.CountUnique(bucket, value, opts...)
+}
+
+// Histogram is stored with the timings.
+func (c *MockStatsdClient) Histogram(bucket string, value, sampleRate float64, opts ...statsd.MetricOption) {
+	c.timing(statsd.HistogramMetric, bucket, strconv.FormatFloat(value, 'f', -1, 64), sampleRate, opts)
+}
+
+// HistogramBuckets records a count for each bucket the value falls in, named
+// as the client names them.
+func (c *MockStatsdClient) HistogramBuckets(bucket string, value float64, bounds []float64, opts ...statsd.MetricOption) {
+	for _, bound := range bounds {
+		if value <= bound {
+			c.Count(bucket+".le_"+strings.ReplaceAll(strconv.FormatFloat(bound, 'f', -1, 64), ".", "_"), 1, 1, opts...)
+		}
+	}
+	c.Count(bucket+".le_inf", 1, 1, opts...)
+}
+
+// GaugeMulti records the value for each bucket like Gauge does.
+func (c *MockStatsdClient) GaugeMulti(value float64, buckets ...string) {
+	for _, bucket := range buckets {
+		c.Gauge(bucket, value)
+	}
+}
+
+// CountMulti records the value for each bucket like Count does.
+func (c *MockStatsdClient) CountMulti(value float64, buckets ...string) {
+	for _, bucket := range buckets {
+		c.Count(bucket, value, 1)
+	}
+}
+
+// TimingMulti records the value for each bucket like Timing does.
+func (c *MockStatsdClient) TimingMulti(value float64, buckets ...string) {
+	for _, bucket := range buckets {
+		c.Timing(bucket, value)
+	}
+}
+
+// HistogramBatch records each value like Histogram does.
+func (c *MockStatsdClient) HistogramBatch(bucket string, values []float64, sampleRate float64, tags ...string) {
+	for _, value := range values {
+		c.Histogram(bucket, value, sampleRate, statsd.MetricTags(tags...))
+	}
+}
+
+// Distribution is stored with the timings.
+func (c *MockStatsdClient) Distribution(bucket string, value, sampleRate float64, opts ...statsd.MetricOption) {
+	c.timing(statsd.DistributionMetric, bucket, strconv.FormatFloat(value, 'f', -1, 64), sampleRate, opts)
+}
+
+// Observe records metrics like the typed methods do, with the metric's sample
+// rate. Histograms and distributions are stored with the timings.
+func (c *MockStatsdClient) Observe(m statsd.Metric) {
+	sampleRate := m.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+	tags := statsd.MetricTags(m.Tags...)
+
+	switch m.Type {
+	case statsd.CountMetric:
+		c.Count(m.Bucket, m.Value, sampleRate, tags)
+	case statsd.GaugeMetric:
+		c.gauge(m.Bucket, strconv.FormatFloat(m.Value, 'f', -1, 64), sampleRate, []statsd.MetricOption{tags})
+	case statsd.TimingMetric:
+		c.TimeInMilliseconds(m.Bucket, m.Value, sampleRate, tags)
+	case statsd.HistogramMetric:
+		c.Histogram(m.Bucket, m.Value, sampleRate, tags)
+	case statsd.DistributionMetric:
+		c.Distribution(m.Bucket, m.Value, sampleRate, tags)
+	case statsd.SetMetric:
+		c.CountUnique(m.Bucket, m.SetValue, tags)
+	}
+}
+
+func (c *MockStatsdClient) SendRaw(line string) {
+	c.RawLines = append(c.RawLines, line)
+}
+
+// WriteBytes records each line of p in RawLines.
+func (c *MockStatsdClient) WriteBytes(p []byte) error {
+	for _, line := range strings.Split(string(p), "\n") {
+		if line = strings.TrimSuffix(line, "\r"); line != "" {
+			c.SendRaw(line)
+		}
+	}
+	return nil
+}
+
+func (c *MockStatsdClient) Stats() statsd.Stats {
+	return statsd.Stats{}
+}
+
+func (c *MockStatsdClient) SnapshotStats() statsd.Stats {
+	return statsd.Stats{}
+}
+
+func (c *MockStatsdClient) Config() statsd.Config {
+	return statsd.Config{}
+}
+
+func (c *MockStatsdClient) DefaultTags() []string {
+	return nil
+}
+
+func (c *MockStatsdClient) Prefix() string {
+	return c.prefix
+}
+
+func (c *MockStatsdClient) SetPrefix(prefix string) error {
+	c.prefix = prefix
+	return nil
+}
+
+func (c *MockStatsdClient) RecentLines() []string {
+	return nil
 }
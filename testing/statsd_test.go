@@ -0,0 +1,95 @@
+package statsd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stvp/gostatsd"
+)
+
+func newMock() *MockStatsdClient {
+	return &MockStatsdClient{
+		Counts:  map[string]string{},
+		Gauges:  map[string]string{},
+		Timings: map[string]string{},
+	}
+}
+
+func TestCallCounts(t *testing.T) {
+	client := newMock()
+	client.Count("a", 1, 1)
+	client.Timing("b", 1)
+	client.TimingDuration("b", time.Second)
+	client.Observe(statsd.Metric{Type: statsd.SetMetric, Bucket: "c", SetValue: "x"})
+
+	if client.CountCalls() != 1 || client.GaugeCalls() != 0 || client.TimingCalls() != 2 || client.SetCalls() != 1 {
+		t.Errorf("Expected 1 count, 0 gauges, 2 timings and 1 set but got %d, %d, %d and %d",
+			client.CountCalls(), client.GaugeCalls(), client.TimingCalls(), client.SetCalls())
+	}
+}
+
+func TestFlushErr(t *testing.T) {
+	client := newMock()
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	client.FlushErr = errors.New("boom")
+	if err := client.Flush(); err != client.FlushErr {
+		t.Errorf("Expected %#v but got %#v", client.FlushErr, err)
+	}
+	if client.FlushCalls() != 2 {
+		t.Errorf("Expected 2 flushes but got %d", client.FlushCalls())
+	}
+}
+
+func TestGaugeBoolAndState(t *testing.T) {
+	client := newMock()
+	client.GaugeBool("leader", true)
+	client.GaugeBool("healthy", false)
+	client.GaugeState("phase", 2)
+
+	if client.Gauges["leader"] != "1" || client.Gauges["healthy"] != "0" || client.Gauges["phase"] != "2" {
+		t.Errorf("Expected leader=1, healthy=0 and phase=2 but got %#v", client.Gauges)
+	}
+}
+
+func TestCalls(t *testing.T) {
+	client := newMock()
+	client.Count("a", 1, 0.1, statsd.MetricTags("env:test"))
+	client.Count("a", 2, 1)
+	client.Histogram("b", 3, 0.5)
+	client.Observe(statsd.Metric{Type: statsd.GaugeMetric, Bucket: "c", Value: 4, Tags: []string{"x"}})
+
+	calls := client.CallsTo("a")
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 calls but got %#v", calls)
+	}
+	if calls[0].SampleRate != 0.1 || len(calls[0].Tags) != 1 || calls[0].Tags[0] != "env:test" {
+		t.Errorf("Expected rate 0.1 with tag env:test but got %#v", calls[0])
+	}
+	if calls[1].Value != "2" || calls[1].SampleRate != 1 || calls[1].Tags != nil {
+		t.Errorf("Expected value 2 at rate 1 without tags but got %#v", calls[1])
+	}
+
+	calls = client.CallsTo("b")
+	if len(calls) != 1 || calls[0].Type != statsd.HistogramMetric || calls[0].SampleRate != 0.5 {
+		t.Errorf("Expected a histogram at rate 0.5 but got %#v", calls)
+	}
+
+	calls = client.CallsTo("c")
+	if len(calls) != 1 || calls[0].Type != statsd.GaugeMetric || len(calls[0].Tags) != 1 {
+		t.Errorf("Expected a tagged gauge but got %#v", calls)
+	}
+
+	// Sampled timings and gauges keep their rate.
+	client.Observe(statsd.Metric{Type: statsd.TimingMetric, Bucket: "d", Value: 12, SampleRate: 0.25})
+	client.Observe(statsd.Metric{Type: statsd.GaugeMetric, Bucket: "e", Value: 3, SampleRate: 0.5})
+	if calls = client.CallsTo("d"); len(calls) != 1 || calls[0].Type != statsd.TimingMetric || calls[0].SampleRate != 0.25 {
+		t.Errorf("Expected a timing at rate 0.25 but got %#v", calls)
+	}
+	if calls = client.CallsTo("e"); len(calls) != 1 || calls[0].Value != "3" || calls[0].SampleRate != 0.5 {
+		t.Errorf("Expected a gauge of 3 at rate 0.5 but got %#v", calls)
+	}
+}
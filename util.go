@@ -4,9 +4,16 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"unicode"
 )
 
 func parseUrl(statsdUrl string) (host, prefix string, err error) {
+	return parseUrlWithSeparator(statsdUrl, ".")
+}
+
+// parseUrlWithSeparator is the same as parseUrl, except that a non-blank
+// prefix is made to end with the given separator instead of a period.
+func parseUrlWithSeparator(statsdUrl, separator string) (host, prefix string, err error) {
 	parsedStatsdUrl, err := url.Parse(statsdUrl)
 	if err != nil {
 		return "", "", err
@@ -15,10 +22,50 @@ func parseUrl(statsdUrl string) (host, prefix string, err error) {
 		return "", "", fmt.Errorf("%#v is missing a valid hostname", statsdUrl)
 	}
 
-	prefix = strings.TrimPrefix(parsedStatsdUrl.Path, "/")
-	if len(prefix) > 0 && prefix[len(prefix)-1] != '.' {
-		prefix = prefix + "."
+	prefix, err = makePrefix(strings.TrimPrefix(parsedStatsdUrl.Path, "/"), separator)
+	if err != nil {
+		return "", "", err
 	}
 
 	return parsedStatsdUrl.Host, prefix, nil
 }
+
+// makePrefix checks that prefix doesn't contain any characters that are
+// reserved in the statsd line format, which would corrupt every metric, and
+// makes a non-blank prefix end with separator.
+func makePrefix(prefix, separator string) (string, error) {
+	for _, r := range prefix {
+		if isReserved(r) {
+			return "", fmt.Errorf("%w: %#v contains %q", ErrInvalidPrefix, prefix, r)
+		}
+	}
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, separator) {
+		prefix = prefix + separator
+	}
+	return prefix, nil
+}
+
+// trimSeparators removes separators from both ends of prefix and collapses
+// repeated ones into one.
+func trimSeparators(prefix, separator string) string {
+	if separator == "" {
+		return prefix
+	}
+	parts := strings.Split(prefix, separator)
+	kept := parts[:0]
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, separator)
+}
+
+// isReserved reports whether r has a special meaning in a statsd line.
+func isReserved(r rune) bool {
+	switch r {
+	case ':', '|', '@', '#':
+		return true
+	}
+	return unicode.IsSpace(r) || unicode.IsControl(r)
+}
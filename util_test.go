@@ -1,6 +1,8 @@
 package statsd
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -12,6 +14,9 @@ func TestParseUrl(t *testing.T) {
 		{"statsd://a.b.com", "a.b.com", "", true},
 		{"statsd://a.b.com/foo.bar", "a.b.com", "foo.bar.", true},
 		{"statsd://a.b.com/foo.bar.", "a.b.com", "foo.bar.", true},
+		{"statsd://a.b.com/foo:bar", "", "", false},
+		{"statsd://a.b.com/foo%20bar", "", "", false},
+		{"statsd://a.b.com/foo|bar", "", "", false},
 	}
 
 	for _, test := range tests {
@@ -30,3 +35,62 @@ func TestParseUrl(t *testing.T) {
 		}
 	}
 }
+
+func TestParseUrlWithSeparator(t *testing.T) {
+	tests := []parseUrlTestcase{
+		{"statsd://a.b.com", "a.b.com", "", true},
+		{"statsd://a.b.com/foo", "a.b.com", "foo_", true},
+		{"statsd://a.b.com/foo_", "a.b.com", "foo_", true},
+		{"statsd://a.b.com/foo.", "a.b.com", "foo._", true},
+	}
+
+	for _, test := range tests {
+		_, prefix, err := parseUrlWithSeparator(test.url, "_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.prefix != prefix {
+			t.Errorf("Expected prefix %#v but got %#v", test.prefix, prefix)
+		}
+	}
+}
+
+func TestMakePrefix(t *testing.T) {
+	for _, prefix := range []string{"a:b", "a b", "a|b", "a@b", "a#b", "a\nb", "a\tb"} {
+		if _, err := makePrefix(prefix, "."); !errors.Is(err, ErrInvalidPrefix) {
+			t.Errorf("Expected ErrInvalidPrefix for %#v but got %#v", prefix, err)
+		}
+	}
+
+	// The reserved character is reported whole, even if it's multibyte.
+	_, err := makePrefix("a\u00a0b", ".")
+	if err == nil || !strings.HasSuffix(err.Error(), `contains '\u00a0'`) {
+		t.Errorf("Expected an error naming U+00A0 but got %v", err)
+	}
+
+	prefix, err := makePrefix("app-1_x", ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix != "app-1_x." {
+		t.Errorf("Expected %#v but got %#v", "app-1_x.", prefix)
+	}
+}
+
+func TestTrimSeparators(t *testing.T) {
+	tests := map[string]string{
+		".app.":        "app",
+		"app..":        "app",
+		"..app..web..": "app.web",
+		"app.web":      "app.web",
+		"...":          "",
+	}
+	for prefix, expected := range tests {
+		if got := trimSeparators(prefix, "."); got != expected {
+			t.Errorf("Expected %#v but got %#v", expected, got)
+		}
+	}
+	if got := trimSeparators("__app__", "__"); got != "app" {
+		t.Errorf("Expected %#v but got %#v", "app", got)
+	}
+}
@@ -0,0 +1,49 @@
+package statsd
+
+import (
+	"bytes"
+	"sync"
+)
+
+// LineWriter is an io.Writer that sends each newline-terminated line written
+// to it through a Client using SendRaw. This makes it possible to io.Copy
+// already formatted statsd lines, such as the output of another process, into
+// a client's buffer.
+//
+// A line split across several calls to Write is held until its newline is
+// written. Empty lines are ignored.
+type LineWriter struct {
+	client Client
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+// NewLineWriter returns a LineWriter that sends lines through client.
+func NewLineWriter(client Client) *LineWriter {
+	return &LineWriter{client: client}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimSuffix(w.partial[:i], []byte{'\r'}); len(line) > 0 {
+			w.client.SendRaw(string(line))
+		}
+		w.partial = w.partial[i+1:]
+	}
+
+	// Don't keep growing the backing array of a long-lived writer.
+	if len(w.partial) == 0 {
+		w.partial = nil
+	}
+
+	return len(p), nil
+}
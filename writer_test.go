@@ -0,0 +1,25 @@
+package statsd
+
+import (
+	"github.com/stvp/go-udp-testing"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	udp.SetAddr(":8125")
+	client := goodClient("ignored", 512)
+	writer := NewLineWriter(client)
+
+	udp.ShouldReceiveOnly(t, "a:1|c\nb:2|g\nc:3|ms", func() {
+		io.Copy(writer, strings.NewReader("a:1|c\nb:2"))
+		writer.Write([]byte("|g\n\nc:3|ms\r\nd:4"))
+		client.Flush()
+	})
+
+	udp.ShouldReceiveOnly(t, "d:4|c", func() {
+		writer.Write([]byte("|c\n"))
+		client.Flush()
+	})
+}